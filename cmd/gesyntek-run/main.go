@@ -1,89 +1,77 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/hdevillers/go-gesyntek/gesyntek"
+	"github.com/hdevillers/go-gesyntek/kmer"
 )
 
 func main() {
-	gff := flag.String("gff", "", "GFF input file (loci description).")
-	gffTarget := flag.String("gff-target", gesyntek.GFF_TARGET, "GFF feature type to target.")
-	gffID := flag.String("gff-id", gesyntek.GFF_ID, "GFF description flag to define the locus ID.")
-	fasta := flag.String("fasta", "", "Input Fasta file(s).")
-	kmerLen := flag.Int("kmer-length", gesyntek.KMER_LEN, "Kmer length to consider.")
-	windowLen := flag.Int("window-length", gesyntek.WINDOW_LEN, "Window length around loci.")
-	distMethod := flag.String("dist-method", "Euclidean", "Kmer distance method.")
-	distDigit := flag.Int("dist-digit", 4, "Number of digits to keep to output distance values.")
-	writeFasta := flag.Bool("write-fasta", false, "Write out up and down stream sequence of each loci as Fasta files.")
-	writeKmerCounts := flag.Bool("write-counts", false, "Write out up/downstream Kmer counts in tabulated format (TSV).")
-	baseOutput := flag.String("output-base", "GeSynteK_output", "Output base path.")
-	standardize := flag.Bool("standardize", false, "Standardize kmer counts before computing distances.")
+	opt := gesyntek.NewOptions()
+	flag.StringVar(&opt.Gff, "gff", "", "GFF input file (loci description).")
+	flag.StringVar(&opt.GffTarget, "gff-target", gesyntek.GFF_TARGET, "GFF feature type to target.")
+	flag.StringVar(&opt.GffId, "gff-id", gesyntek.GFF_ID, "GFF description flag to define the locus ID.")
+	flag.StringVar(&opt.Fasta, "fasta", "", "Input Fasta file(s).")
+	flag.IntVar(&opt.KmerLen, "kmer-length", gesyntek.KMER_LEN, "Kmer length to consider.")
+	flag.IntVar(&opt.WindowLen, "window-length", gesyntek.WINDOW_LEN, "Window length around loci.")
+	flag.StringVar(&opt.DistMethod, "dist-method", "Euclidean", "Kmer distance method (case-insensitive name or alias, see -list-dist-methods).")
+	flag.Float64Var(&opt.DistPseudoCount, "dist-pseudocount", kmer.DefaultPseudoCount, "Pseudocount added to counts by the JensenShannon, JSDivergence and SymKL distances.")
+	flag.IntVar(&opt.MarkovOrder, "markov-order", kmer.DefaultMarkovOrder, "Order of the Markov background used by the D2S and D2Star distances (at most kmer length - 2).")
+	flag.BoolVar(&opt.GramMatrix, "gram-matrix", false, "Compute Euclidean and Cosine distances of all pairs from a single matrix product (faster with thousands of loci, less precise between close flanks).")
+	flag.IntVar(&opt.DistDigit, "dist-digit", 4, "Number of digits to keep to output distance values.")
+	flag.BoolVar(&opt.WriteFasta, "write-fasta", false, "Write out up and down stream sequence of each loci as Fasta files.")
+	flag.BoolVar(&opt.WriteCounts, "write-counts", false, "Write out up/downstream Kmer counts in tabulated format (TSV).")
+	flag.StringVar(&opt.CountsFormat, "counts-format", kmer.CountsFormatTSV, "Format of written counts: tsv (one column per locus), long (sparse kmer, locus, count triplets) or mtx (Matrix Market with row and column label files).")
+	flag.StringVar(&opt.OutputBase, "output-base", "GeSynteK_output", "Output base path.")
+	flag.BoolVar(&opt.Standardize, "standardize", false, "Standardize kmer counts before computing distances (same as -normalize zscore).")
+	flag.StringVar(&opt.Normalize, "normalize", "", "Normalize kmer counts before computing distances: freq, clr, presence or zscore (raw counts by default).")
+	flag.Float64Var(&opt.PseudoCount, "pseudocount", kmer.DefaultPseudoCount, "Pseudocount added to counts by the clr normalization.")
+	flag.StringVar(&opt.Cache, "cache", "", "Binary cache file of per-locus kmer counts (reused if inputs and settings match).")
+	flag.BoolVar(&opt.Append, "append", false, "Append the loci of the input GFF/Fasta files to the analysis stored in the cache and the previous pairwise table.")
+	flag.BoolVar(&opt.Canonical, "canonical", false, "Count canonical kmers.")
+	flag.BoolVar(&opt.CrossFlank, "cross-flank", false, "Also compare upstream against downstream flanks (reverse complemented) to detect inversions and classify each pair.")
+	flag.Float64Var(&opt.CrossThreshold, "cross-threshold", gesyntek.CROSS_THRESHOLD, "Maximal distance between two matching flanks (pair classification, required with -cross-flank as it depends on the distance method).")
+	flag.StringVar(&opt.UnknownStrand, "unknown-strand", gesyntek.STRAND_BOTH, "Handling of loci of unknown strand ('.' or '?'): "+
+		gesyntek.STRAND_BOTH+" (compare both orientations and keep the better one) or "+gesyntek.STRAND_EXCLUDE+" (skip them).")
+	flag.BoolVar(&opt.SoftMask, "soft-mask", false, "Treat lowercase (soft-masked) bases as masked: they split sequences like N.")
+	flag.BoolVar(&opt.WriteStats, "write-stats", false, "Write out the number of degenerated, masked and too-short-fragment bases skipped in each flank (TSV).")
+	maskFeatures := flag.String("mask-features", "", "Comma-separated list of GFF feature types masked in flanks before counting (e.g. CDS,exon,tRNA).")
+	flag.BoolVar(&opt.Intergenic, "intergenic", false, "Mask all annotated features in flanks (keep only intergenic spacers).")
+	quiet := flag.Bool("quiet", false, "Do not print progress on stderr.")
+	listDist := flag.Bool("list-dist-methods", false, "List the available kmer distance methods and exit.")
 
 	flag.Parse()
 
-	if *gff == "" {
-		panic("You must provide an input GFF file.")
+	if *listDist {
+		kmer.WriteKDistMethods(os.Stdout)
+		return
 	}
 
-	if *fasta == "" {
-		panic("You must provide an input Fasta file.")
+	if *maskFeatures != "" {
+		opt.MaskFeatures = strings.Split(*maskFeatures, ",")
 	}
-
-	// Initialize the structure
-	gsk := gesyntek.NewGeSynteK(*windowLen, *kmerLen, *gffTarget, *gffID, *distMethod, *distDigit)
-
-	// Load the GFF file
-	err := gsk.LoadGFF(*gff)
-	if err != nil {
-		panic(err)
-	}
-
-	// Extract the up and down stream sequences of each locus
-	err = gsk.LoadFasta(*fasta)
-	if err != nil {
-		panic(err)
-	}
-
-	// Count Kmers in up and down stream sequences
-	err = gsk.CountKmers()
-	if err != nil {
-		panic(err)
-	}
-
-	// Standardize if required
-	if *standardize {
-		gsk.StandardizeCounts()
-	}
-
-	// Merge counts (if necessary)
-	err = gsk.MergeKmers()
-	if err != nil {
-		panic(err)
-	}
-
-	// Compute all pairwise distances
-	err = gsk.ComputeKmerDistance()
-	if err != nil {
-		panic(err)
+	if !*quiet {
+		opt.Progress = kmer.ProgressPrinter(os.Stderr)
+		// Only warnings (e.g. excluded loci) are logged
+		opt.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
 	}
 
-	// Save up and down stream sequences if required
-	if *writeFasta {
-		gsk.WriteUpDownFasta(*baseOutput)
-	}
-
-	// Save up/downstream kmer counts if required
-	if *writeKmerCounts {
-		err = gsk.WriteKmerCounts(*baseOutput)
-		if err != nil {
-			panic(err)
-		}
-	}
+	// Stop properly on interruption
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// Save up/downstream distance for each pair of Loci
-	err = gsk.WritePairwiseDistance(*baseOutput)
+	// Run all the steps
+	err := gesyntek.NewPipeline(opt).Run(ctx)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "gesyntek-run: %s\n", err.Error())
+		stop()
+		os.Exit(1)
 	}
 }
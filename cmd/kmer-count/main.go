@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/hdevillers/go-gesyntek/kmer"
 )
@@ -19,21 +20,90 @@ func (i *inputFlag) Set(value string) error {
 }
 
 var inputs inputFlag
+var sketchInputs inputFlag
 
 func main() {
 	flag.Var(&inputs, "input", "Input sequence file(s).")
 	format := flag.String("format", "fasta", "Format of the input sequence file(s).")
 	outputBase := flag.String("output-base", "GeSynteK_kmer", "Output base path.")
 	kmerLen := flag.Int("kmer-length", 4, "Kmer length.")
-	standardize := flag.Bool("standardize", false, "Standardize kmer counts.")
+	standardize := flag.Bool("standardize", false, "Standardize kmer counts (same as -normalize zscore).")
+	normalize := flag.String("normalize", "", "Normalize kmer counts: freq, clr, presence or zscore (raw counts by default).")
+	pseudoCount := flag.Float64("pseudocount", kmer.DefaultPseudoCount, "Pseudocount added to counts by the clr normalization.")
 	canonical := flag.Bool("canonical", false, "Count canonical kmers.")
+	softMask := flag.Bool("soft-mask", false, "Treat lowercase (soft-masked) bases as masked: they split sequences like N.")
+	writeStats := flag.Bool("write-stats", false, "Write out the number of degenerated, masked and too-short-fragment bases skipped in each input (TSV).")
+	writeSpectrum := flag.Bool("write-spectrum", false, "Write out the kmer abundance histogram of each input and peak-based estimates of kmer coverage and genome size (TSV).")
+	spectrumMax := flag.Int("spectrum-max", kmer.DefaultSpectrumMax, "Highest multiplicity of the kmer abundance histogram: more frequent kmers are counted in the last bin (max + 1).")
+	quiet := flag.Bool("quiet", false, "Do not print progress on stderr.")
+	sketch := flag.Bool("sketch", false, "Build MinHash sketches instead of counting kmers, then write sketches and pairwise Mash distances.")
+	sketchSize := flag.Int("sketch-size", kmer.DefaultSketchSize, "Number of hash values kept in each sketch.")
+	flag.Var(&sketchInputs, "sketch-input", "Sketch file(s) to compare with the input sequences (sketch mode).")
+	distMethod := flag.String("dist-method", "", "Also write pairwise distances between inputs with this method (case-insensitive name or alias, see -list-dist-methods).")
+	gramMatrix := flag.Bool("gram-matrix", false, "Compute Euclidean and Cosine distances of all pairs from a single matrix product (faster with many inputs, less precise between close inputs).")
+	distPseudoCount := flag.Float64("dist-pseudocount", kmer.DefaultPseudoCount, "Pseudocount added to counts by the JensenShannon, JSDivergence and SymKL distances.")
+	markovOrder := flag.Int("markov-order", kmer.DefaultMarkovOrder, "Order of the Markov background used by the D2S and D2Star distances (at most kmer length - 2).")
+	countsFormat := flag.String("counts-format", kmer.CountsFormatTSV, "Format of written counts: tsv (one column per input), long (sparse kmer, sample, count triplets) or mtx (Matrix Market with row and column label files).")
+	writeDB := flag.Bool("write-db", false, "Write out raw kmer counts into a binary database with an index for fast kmer lookups (<output-base>_KmerCounts.gkdb).")
+	noCounts := flag.Bool("no-counts", false, "Do not write kmer counts (kmers are not merged if the distance method supports sparse profiles).")
+	maxMemory := flag.Int64("max-memory", 0, "Memory limit (MB) of kmer buffers for K > 8: sorted runs are written into temporary files and merged (unbounded by default).")
+	tmpDir := flag.String("tmp-dir", "", "Directory of temporary files (system default if empty).")
+	minCount := flag.Float64("min-count", 0, "Only write kmers with at least this count in a sample (or in total with -filter-total).")
+	maxCount := flag.Float64("max-count", 0, "Only write kmers with at most this count in a sample (or in total with -filter-total, unbounded if 0).")
+	filterTotal := flag.Bool("filter-total", false, "Apply -min-count and -max-count to the summed counts of all inputs instead of each input.")
+	topN := flag.Int("top", 0, "Only write the N most abundant kmers (summed over inputs), by decreasing abundance.")
+	dropCore := flag.Bool("drop-core", false, "Do not write kmers present in every input (several inputs only).")
+	dropUnique := flag.Bool("drop-unique", false, "Do not write kmers present in a single input (several inputs only).")
+	threads := flag.Int("threads", 1, "Number of workers counting the sequences of each input file.")
+	listDist := flag.Bool("list-dist-methods", false, "List the available kmer distance methods and exit.")
 	flag.Parse()
 
-	if len(inputs) == 0 {
+	if *listDist {
+		kmer.WriteKDistMethods(os.Stdout)
+		return
+	}
+	if len(inputs) == 0 && (!*sketch || len(sketchInputs) == 0) {
 		panic("You must provide an input sequence file.")
 	}
+	var norm kmer.Normalizer
+	if *standardize {
+		norm = kmer.NewKNormZScore()
+	} else if *normalize != "" {
+		var err error
+		norm, err = kmer.NewNormalizer(*normalize, *pseudoCount)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	km := kmer.NewKmer(*kmerLen, *canonical)
+	km.SoftMask = *softMask
+	km.MaxMemory = *maxMemory << 20
+	km.TmpDir = *tmpDir
+	km.Threads = *threads
+	km.CountsFormat = *countsFormat
+	if err := kmer.CheckCountsFormat(*countsFormat); err != nil {
+		panic(err)
+	}
+	if !*quiet {
+		km.Progress = kmer.NewProgressReporter(kmer.ProgressPrinter(os.Stderr), nil)
+	}
+
+	if *distMethod != "" {
+		p := kmer.NewKDistParams(*kmerLen)
+		p.PseudoCount = *distPseudoCount
+		p.MarkovOrder = *markovOrder
+		km.GramMatrix = *gramMatrix
+		err := km.SetDistMethod(*distMethod, p)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if *sketch {
+		runSketch(km, *format, *sketchSize, *outputBase)
+		return
+	}
 
 	// Load sequence
 	for i := range len(inputs) {
@@ -43,21 +113,100 @@ func main() {
 		}
 	}
 
-	// Standardize counts if required
-	if *standardize {
-		km.StandardizeCounts()
+	// Write out kmer spectra (on raw counts)
+	var err error
+	if *writeSpectrum {
+		err = km.WriteSpectra(*outputBase, *spectrumMax)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	// Merge kmer
-	err := km.MergeKmers()
-	if err != nil {
-		panic(err)
+	// Merge kmer (not required by sparse profiles if counts are not
+	// written)
+	if !*noCounts || *writeDB || !km.CanUseSparse(norm) {
+		err = km.MergeKmers()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Select kmers to write (on raw counts)
+	if !*noCounts || *writeDB {
+		err = km.FilterKmers(kmer.KFilter{
+			MinCount:   *minCount,
+			MaxCount:   *maxCount,
+			Total:      *filterTotal,
+			TopN:       *topN,
+			DropCore:   *dropCore,
+			DropUnique: *dropUnique,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Write out the count database (raw counts)
+	if *writeDB {
+		err = km.WriteCountDB(*outputBase)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Normalize counts if required
+	if norm != nil {
+		km.NormalizeCounts(norm)
+	}
+
+	// Write out skipped bases
+	if *writeStats {
+		err = km.WriteSkippedBases(*outputBase)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	// Write out kmer counts
-	err = km.WriteKmerCounts(*outputBase)
+	if !*noCounts {
+		err = km.WriteKmerCounts(*outputBase)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Write out distances between inputs
+	if km.Dist != nil {
+		err = km.WriteDistances(*outputBase)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+}
+
+// Sketch input sequences, load other sketches and compare them all
+func runSketch(km *kmer.Kmer, format string, size int, outputBase string) {
+	for i := range len(inputs) {
+		err := km.SketchSequences(inputs[i], format, size)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if len(inputs) > 0 {
+		err := km.WriteSketches(outputBase)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for i := range len(sketchInputs) {
+		err := km.LoadSketches(sketchInputs[i])
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := km.WriteSketchDistances(outputBase)
 	if err != nil {
 		panic(err)
 	}
-
 }
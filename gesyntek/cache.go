@@ -0,0 +1,380 @@
+package gesyntek
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/hdevillers/go-gesyntek/kmer"
+)
+
+/*
+	Binary cache of per-locus kmer counts
+*/
+
+const (
+	CACHE_MAGIC   string = "GSKC"
+	CACHE_VERSION uint64 = 4
+)
+
+// Cache header: a cache is reused only if all fields match
+type CacheHeader struct {
+	KmerLen   int
+	Canonical bool
+	SoftMask  bool
+	WindowLen int
+	GffTarget string
+	GffId     string
+	FeatMask  string
+	Strand    string   // Handling of unknown-strand loci
+	Checksums []string // Input checksums (of all input sets once appended)
+}
+
+// Compute the SHA-256 checksum of a file
+func FileChecksum(file string) (string, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, fh)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Build the cache header corresponding to the current settings and inputs
+func (gsk *GeSynteK) NewCacheHeader(inputs ...string) (*CacheHeader, error) {
+	var hdr CacheHeader
+	hdr.KmerLen = gsk.KmerLen
+	hdr.Canonical = gsk.Canonical
+	hdr.SoftMask = gsk.SoftMask
+	hdr.WindowLen = gsk.WindowLen
+	hdr.GffTarget = gsk.GffTarget
+	hdr.GffId = gsk.GffId
+	hdr.FeatMask = gsk.FeatureMask()
+	hdr.Strand = gsk.UnknownStrand
+	hdr.Checksums = make([]string, len(inputs))
+	for i := range len(inputs) {
+		sum, err := FileChecksum(inputs[i])
+		if err != nil {
+			return nil, err
+		}
+		hdr.Checksums[i] = sum
+	}
+	return &hdr, nil
+}
+
+// Check if two headers describe the same analysis (an appended cache
+// holds several input sets and only matches later appends)
+func (hdr *CacheHeader) Match(o *CacheHeader) bool {
+	return hdr.MatchSettings(o) && slices.Equal(hdr.Checksums, o.Checksums)
+}
+
+// Check if two headers share the same settings (inputs are ignored)
+func (hdr *CacheHeader) MatchSettings(o *CacheHeader) bool {
+	return hdr.KmerLen == o.KmerLen &&
+		hdr.Canonical == o.Canonical &&
+		hdr.SoftMask == o.SoftMask &&
+		hdr.WindowLen == o.WindowLen &&
+		hdr.GffTarget == o.GffTarget &&
+		hdr.GffId == o.GffId &&
+		hdr.FeatMask == o.FeatMask &&
+		hdr.Strand == o.Strand
+}
+
+func writeCacheHeader(w *bufio.Writer, hdr *CacheHeader) error {
+	_, err := w.WriteString(CACHE_MAGIC)
+	if err != nil {
+		return err
+	}
+	canonical := uint64(0)
+	if hdr.Canonical {
+		canonical = 1
+	}
+	softMask := uint64(0)
+	if hdr.SoftMask {
+		softMask = 1
+	}
+	for _, v := range []uint64{CACHE_VERSION, uint64(hdr.KmerLen), canonical, softMask, uint64(hdr.WindowLen)} {
+		err = kmer.WriteUvarint(w, v)
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range []string{hdr.GffTarget, hdr.GffId, hdr.FeatMask, hdr.Strand} {
+		err = kmer.WriteString(w, s)
+		if err != nil {
+			return err
+		}
+	}
+	err = kmer.WriteUvarint(w, uint64(len(hdr.Checksums)))
+	if err != nil {
+		return err
+	}
+	for _, s := range hdr.Checksums {
+		err = kmer.WriteString(w, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCacheHeader(r *bufio.Reader) (*CacheHeader, error) {
+	magic := make([]byte, len(CACHE_MAGIC))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != CACHE_MAGIC {
+		return nil, errors.New("not a GeSynteK cache file")
+	}
+	vals := make([]uint64, 5)
+	for i := range vals {
+		vals[i], err = kmer.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if vals[0] != CACHE_VERSION {
+		return nil, errors.New("unsupported GeSynteK cache version")
+	}
+
+	var hdr CacheHeader
+	hdr.KmerLen = int(vals[1])
+	hdr.Canonical = vals[2] == 1
+	hdr.SoftMask = vals[3] == 1
+	hdr.WindowLen = int(vals[4])
+	hdr.GffTarget, err = kmer.ReadString(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr.GffId, err = kmer.ReadString(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr.FeatMask, err = kmer.ReadString(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Strand, err = kmer.ReadString(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := kmer.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Checksums = make([]string, n)
+	for i := range hdr.Checksums {
+		hdr.Checksums[i], err = kmer.ReadString(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &hdr, nil
+}
+
+// Write loci and their kmer counts into a cache file
+func (gsk *GeSynteK) WriteCache(file string, hdr *CacheHeader) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	err = writeCacheHeader(fw, hdr)
+	if err != nil {
+		return err
+	}
+
+	err = kmer.WriteUvarint(fw, uint64(len(gsk.Loci)))
+	if err != nil {
+		return err
+	}
+	for i := range len(gsk.Loci) {
+		err = gsk.Loci[i].writeCache(fw)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Load loci and their kmer counts from a cache file. Returns false
+// (and loads nothing) if the file does not exist or does not match
+// the given header.
+func (gsk *GeSynteK) LoadCache(file string, hdr *CacheHeader) (bool, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fr := bufio.NewReader(f)
+	cached, err := readCacheHeader(fr)
+	if err != nil {
+		return false, err
+	}
+	if !cached.Match(hdr) {
+		return false, nil
+	}
+
+	err = gsk.readCacheLoci(fr, hdr)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Load a cache before appending new loci: settings must match but the
+// inputs may differ. Returns the header stored in the cache.
+func (gsk *GeSynteK) LoadCacheForAppend(file string, hdr *CacheHeader) (*CacheHeader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fr := bufio.NewReader(f)
+	cached, err := readCacheHeader(fr)
+	if err != nil {
+		return nil, err
+	}
+	if !cached.MatchSettings(hdr) {
+		return nil, errors.New("cache file was built with different settings")
+	}
+
+	err = gsk.readCacheLoci(fr, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cached loci do not need to be extracted from new inputs
+	gsk.NCachedLoci = len(gsk.Loci)
+	gsk.SeqIdLoci = make(map[string][]int)
+	return cached, nil
+}
+
+// Read all loci following the cache header
+func (gsk *GeSynteK) readCacheLoci(fr *bufio.Reader, hdr *CacheHeader) error {
+	n, err := kmer.ReadUvarint(fr)
+	if err != nil {
+		return err
+	}
+	loci := make([]Locus, n)
+	seqIdLoci := make(map[string][]int)
+	for i := range loci {
+		err = loci[i].readCache(fr, hdr)
+		if err != nil {
+			return err
+		}
+		seqIdLoci[loci[i].SeqId] = append(seqIdLoci[loci[i].SeqId], i)
+	}
+
+	gsk.Loci = loci
+	gsk.SeqIdLoci = seqIdLoci
+	return nil
+}
+
+// Write a single locus (location and counts)
+func (locus *Locus) writeCache(w *bufio.Writer) error {
+	for _, s := range []string{locus.SeqId, locus.SeqLabel, locus.SeqStrand} {
+		err := kmer.WriteString(w, s)
+		if err != nil {
+			return err
+		}
+	}
+	hasUp := uint64(0)
+	if locus.HasUpStr {
+		hasUp = 1
+	}
+	hasDown := uint64(0)
+	if locus.HasDownStr {
+		hasDown = 1
+	}
+	for _, v := range []uint64{uint64(locus.SeqStart), uint64(locus.SeqEnd), hasUp, hasDown,
+		uint64(locus.FeatMaskUpStr), uint64(locus.FeatMaskDownStr)} {
+		err := kmer.WriteUvarint(w, v)
+		if err != nil {
+			return err
+		}
+	}
+	if locus.HasUpStr {
+		err := locus.KmerUpStr.WriteBinary(w)
+		if err != nil {
+			return err
+		}
+	}
+	if locus.HasDownStr {
+		err := locus.KmerDownStr.WriteBinary(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a single locus written by writeCache
+func (locus *Locus) readCache(r *bufio.Reader, hdr *CacheHeader) error {
+	str := make([]string, 3)
+	for i := range str {
+		s, err := kmer.ReadString(r)
+		if err != nil {
+			return err
+		}
+		str[i] = s
+	}
+	vals := make([]uint64, 6)
+	for i := range vals {
+		v, err := kmer.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		vals[i] = v
+	}
+	*locus = *NewLocus(str[0], str[1], int(vals[0]), int(vals[1]), str[2])
+	locus.HasUpStr = vals[2] == 1
+	locus.HasDownStr = vals[3] == 1
+	locus.FeatMaskUpStr = int(vals[4])
+	locus.FeatMaskDownStr = int(vals[5])
+
+	var err error
+	locus.KmerUpStr, err = kmer.NewKCount(hdr.KmerLen, hdr.Canonical)
+	if err != nil {
+		return err
+	}
+	locus.KmerDownStr, err = kmer.NewKCount(hdr.KmerLen, hdr.Canonical)
+	if err != nil {
+		return err
+	}
+	locus.KmerUpStr.SetSoftMask(hdr.SoftMask)
+	locus.KmerDownStr.SetSoftMask(hdr.SoftMask)
+	if locus.HasUpStr {
+		err = locus.KmerUpStr.ReadBinary(r)
+		if err != nil {
+			return err
+		}
+	}
+	if locus.HasDownStr {
+		err = locus.KmerDownStr.ReadBinary(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
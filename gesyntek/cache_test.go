@@ -0,0 +1,156 @@
+package gesyntek
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Options reading the example loci
+func testOptions(t *testing.T) Options {
+	opt := NewOptions()
+	opt.Gff = "../examples/test.gff"
+	opt.Fasta = "../examples/test.fasta"
+	opt.WindowLen = 2000
+	opt.KmerLen = 6
+	opt.OutputBase = filepath.Join(t.TempDir(), "test")
+	return opt
+}
+
+// Run a pipeline and fail on error
+func runPipeline(t *testing.T, opt Options) *GeSynteK {
+	p := NewPipeline(opt)
+	err := p.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error occurred while running the pipeline: %s", err.Error())
+	}
+	return p.GeSynteK
+}
+
+// Test that cached loci and counts are reloaded unchanged and that a cache
+// built with other settings or inputs is ignored
+func TestCache(t *testing.T) {
+	opt := testOptions(t)
+	opt.Cache = filepath.Join(t.TempDir(), "test.gskc")
+	gsk := runPipeline(t, opt)
+
+	hdr, err := gsk.NewCacheHeader(opt.Gff, opt.Fasta)
+	if err != nil {
+		t.Fatalf("Failed to build the cache header: %s", err.Error())
+	}
+	cached := NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+	loaded, err := cached.LoadCache(opt.Cache, hdr)
+	if err != nil {
+		t.Fatalf("Failed to load the cache: %s", err.Error())
+	}
+	if !loaded {
+		t.Fatalf("Expected the cache to match the current settings.")
+	}
+	if len(cached.Loci) != len(gsk.Loci) {
+		t.Fatalf("Expected %d cached loci but found %d.", len(gsk.Loci), len(cached.Loci))
+	}
+	for i := range gsk.Loci {
+		a, b := &gsk.Loci[i], &cached.Loci[i]
+		if a.SeqId != b.SeqId || a.SeqLabel != b.SeqLabel || a.SeqStart != b.SeqStart ||
+			a.SeqEnd != b.SeqEnd || a.SeqStrand != b.SeqStrand {
+			t.Errorf("Unexpected location of cached locus %s.", a.SeqLabel)
+		}
+		if a.HasUpStr != b.HasUpStr || a.HasDownStr != b.HasDownStr {
+			t.Fatalf("Unexpected flanks of cached locus %s.", a.SeqLabel)
+		}
+		if a.HasUpStr && !mat.Equal(a.KmerUpStr.GetCounts(), b.KmerUpStr.GetCounts()) {
+			t.Errorf("Unexpected upstream counts of cached locus %s.", a.SeqLabel)
+		}
+		if a.HasDownStr && !mat.Equal(a.KmerDownStr.GetCounts(), b.KmerDownStr.GetCounts()) {
+			t.Errorf("Unexpected downstream counts of cached locus %s.", a.SeqLabel)
+		}
+	}
+	if !slices.Equal(cached.SeqIdLoci["CHR_02"], gsk.SeqIdLoci["CHR_02"]) {
+		t.Errorf("Expected loci %v on CHR_02 but found %v.", gsk.SeqIdLoci["CHR_02"], cached.SeqIdLoci["CHR_02"])
+	}
+
+	// Any setting or input change invalidates the cache
+	changes := map[string]func(h *CacheHeader){
+		"KmerLen":   func(h *CacheHeader) { h.KmerLen++ },
+		"Canonical": func(h *CacheHeader) { h.Canonical = !h.Canonical },
+		"SoftMask":  func(h *CacheHeader) { h.SoftMask = !h.SoftMask },
+		"WindowLen": func(h *CacheHeader) { h.WindowLen++ },
+		"FeatMask":  func(h *CacheHeader) { h.FeatMask = "CDS" },
+		"Strand":    func(h *CacheHeader) { h.Strand = STRAND_EXCLUDE },
+		"Checksums": func(h *CacheHeader) { h.Checksums[0] = h.Checksums[1] },
+	}
+	for name, change := range changes {
+		other := *hdr
+		other.Checksums = slices.Clone(hdr.Checksums)
+		change(&other)
+		cached := NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+		loaded, err := cached.LoadCache(opt.Cache, &other)
+		if err != nil {
+			t.Fatalf("Failed to load the cache: %s", err.Error())
+		}
+		if loaded || len(cached.Loci) != 0 {
+			t.Errorf("Expected the cache to be ignored when %s changes.", name)
+		}
+	}
+
+	// A missing cache is not an error
+	loaded, err = cached.LoadCache(filepath.Join(t.TempDir(), "missing.gskc"), hdr)
+	if err != nil || loaded {
+		t.Errorf("Expected a missing cache to be ignored.")
+	}
+}
+
+// Test that an appended cache holds the checksums of all input sets and is
+// only reused by later appends
+func TestAppendedCache(t *testing.T) {
+	lines := exampleGFF(t)
+	opt := testOptions(t)
+	opt.Cache = filepath.Join(t.TempDir(), "test.gskc")
+	first := writeGFF(t, lines[:3]...)
+	second := writeGFF(t, lines[3:]...)
+	opt.Gff = first
+	runPipeline(t, opt)
+	opt.Gff = second
+	opt.Append = true
+	gsk := runPipeline(t, opt)
+
+	all, err := gsk.NewCacheHeader(first, opt.Fasta, second, opt.Fasta)
+	if err != nil {
+		t.Fatalf("Failed to build the cache header: %s", err.Error())
+	}
+	cached := NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+	loaded, err := cached.LoadCache(opt.Cache, all)
+	if err != nil || !loaded || len(cached.Loci) != len(lines) {
+		t.Fatalf("Expected the appended cache to hold the checksums of both input sets.")
+	}
+
+	// No plain run matches the appended cache
+	for _, gff := range []string{first, second} {
+		hdr, err := gsk.NewCacheHeader(gff, opt.Fasta)
+		if err != nil {
+			t.Fatalf("Failed to build the cache header: %s", err.Error())
+		}
+		cached := NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+		loaded, err := cached.LoadCache(opt.Cache, hdr)
+		if err != nil {
+			t.Fatalf("Failed to load the cache: %s", err.Error())
+		}
+		if loaded {
+			t.Errorf("Expected the appended cache to be ignored by a plain run.")
+		}
+	}
+
+	// A plain run rewrites it from its own inputs
+	opt.Gff = first
+	opt.Append = false
+	runPipeline(t, opt)
+	hdr, _ := gsk.NewCacheHeader(first, opt.Fasta)
+	cached = NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+	loaded, err = cached.LoadCache(opt.Cache, hdr)
+	if err != nil || !loaded || len(cached.Loci) != 3 {
+		t.Errorf("Expected the cache to be rewritten by a plain run.")
+	}
+}
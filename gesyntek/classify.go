@@ -0,0 +1,53 @@
+package gesyntek
+
+/*
+	Classification of loci pairs from direct and crossed flank distances
+*/
+
+// Pair classes
+const (
+	CLASS_COLLINEAR string = "collinear" // Both flanks match
+	CLASS_INVERTED  string = "inverted"  // Both flanks match after orientation correction
+	CLASS_ONESIDED  string = "one-sided" // A single flank matches
+	CLASS_BROKEN    string = "broken"    // No flank matches
+	CLASS_NA        string = "NA"        // No flank can be compared
+)
+
+// Classify a pair of loci from its distances (upstream, downstream,
+// up against reverse complemented down and down against reverse
+// complemented up, -1 if missing). Flanks match if their distance does not
+// exceed the threshold t. If both orientations match, the closest one is
+// kept.
+func ClassifyPair(d []float64, t float64) string {
+	if len(d) < 4 {
+		return CLASS_NA
+	}
+	nCmp := 0
+	match := make([]bool, 4)
+	for i := range 4 {
+		if d[i] > -0.5 {
+			nCmp++
+			match[i] = d[i] <= t
+		}
+	}
+	if nCmp == 0 {
+		return CLASS_NA
+	}
+	collinear := match[0] && match[1]
+	inverted := match[2] && match[3]
+	if collinear && inverted {
+		// Keep the closest orientation
+		collinear = d[0]+d[1] <= d[2]+d[3]
+		inverted = !collinear
+	}
+	if collinear {
+		return CLASS_COLLINEAR
+	}
+	if inverted {
+		return CLASS_INVERTED
+	}
+	if match[0] || match[1] || match[2] || match[3] {
+		return CLASS_ONESIDED
+	}
+	return CLASS_BROKEN
+}
@@ -0,0 +1,90 @@
+package gesyntek
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// Test the classification of pairs from direct and crossed distances
+func TestClassifyPair(t *testing.T) {
+	cases := []struct {
+		d        []float64
+		expected string
+	}{
+		{[]float64{0.1, 0.1, 0.5, 0.5}, CLASS_COLLINEAR},
+		{[]float64{0.5, 0.5, 0.1, 0.1}, CLASS_INVERTED},
+		{[]float64{0.1, 0.1, 0.15, 0.05}, CLASS_COLLINEAR},
+		{[]float64{0.1, 0.15, 0.1, 0.1}, CLASS_INVERTED},
+		{[]float64{0.1, 0.5, 0.5, 0.5}, CLASS_ONESIDED},
+		{[]float64{-1, 0.1, -1, -1}, CLASS_ONESIDED},
+		{[]float64{0.5, -1, 0.5, -1}, CLASS_BROKEN},
+		{[]float64{-1, -1, -1, -1}, CLASS_NA},
+		{[]float64{0.1, 0.1}, CLASS_NA},
+	}
+	for _, c := range cases {
+		found := ClassifyPair(c.d, 0.2)
+		if found != c.expected {
+			t.Errorf("Expected class %s for distances %v but found %s.", c.expected, c.d, found)
+		}
+	}
+}
+
+// Test that crossed distances detect an inverted locus and that direct
+// distances do not change with crossed comparisons
+func TestCrossFlank(t *testing.T) {
+	// GENE_02 and the same locus on the other strand (its flanks are the
+	// reverse complement of the crossed GENE_02 flanks)
+	lines := exampleGFF(t)
+	gff := writeGFF(t, lines[1], "CHR_02\t.\tgene\t69004\t70800\t.\t-\t.\tID=GENE_02R", lines[3])
+	settings := []struct {
+		k      int
+		method string
+		norm   string
+	}{
+		{6, "Cosine", ""},
+		{10, "Cosine", "zscore"},
+		{10, "Pearson", ""},
+		{10, "JensenShannon", ""},
+		{40, "Euclidean", "clr"},
+	}
+	for _, s := range settings {
+		opt := testOptions(t)
+		opt.Gff = gff
+		opt.KmerLen = s.k
+		opt.DistMethod = s.method
+		opt.Normalize = s.norm
+		direct := runPipeline(t, opt)
+
+		opt.CrossFlank = true
+		err := NewPipeline(opt).Run(context.Background())
+		var oe *OptionError
+		if !errors.As(err, &oe) || oe.Option != "CrossThreshold" {
+			t.Fatalf("Expected a missing cross threshold error but found %v.", err)
+		}
+		opt.CrossThreshold = 1e-6
+		crossed := runPipeline(t, opt)
+
+		for z := range direct.DistValues {
+			for c := range 2 {
+				if crossed.DistValues[z][c] != direct.DistValues[z][c] {
+					t.Errorf("Expected the same direct distance with crossed comparisons for %s (K=%d, %q normalization) but found %f instead of %f.",
+						s.method, s.k, s.norm, crossed.DistValues[z][c], direct.DistValues[z][c])
+				}
+			}
+		}
+
+		// First pair: GENE_02 against GENE_02R
+		d := crossed.DistValues[0]
+		if math.Abs(d[2]) > 1e-9 || math.Abs(d[3]) > 1e-9 {
+			t.Errorf("Expected null crossed distances for %s (K=%d) but found %f and %f.", s.method, s.k, d[2], d[3])
+		}
+		if d[0] <= 1e-6 || d[1] <= 1e-6 {
+			t.Errorf("Expected non-null direct distances for %s (K=%d) but found %f and %f.", s.method, s.k, d[0], d[1])
+		}
+		if c := ClassifyPair(d, opt.CrossThreshold); c != CLASS_INVERTED {
+			t.Errorf("Expected an inverted pair for %s (K=%d) but found %s.", s.method, s.k, c)
+		}
+	}
+}
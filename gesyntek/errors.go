@@ -0,0 +1,63 @@
+package gesyntek
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hdevillers/go-gesyntek/kmer"
+)
+
+/*
+	Errors returned by the GeSynteK pipeline
+*/
+
+var (
+	ErrKTooLarge           = kmer.ErrKTooLarge
+	ErrNoLocus             = errors.New("no locus found in the GFF file")
+	ErrUnsupportedDistance = kmer.ErrUnsupportedDistance
+)
+
+// Invalid value of a pipeline option
+type OptionError struct {
+	Option string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid option %s: %s", e.Option, e.Reason)
+}
+
+// Invalid line in a GFF file
+type GFFLineError struct {
+	File   string
+	Line   int
+	Reason string
+}
+
+func (e *GFFLineError) Error() string {
+	return fmt.Sprintf("invalid GFF line %d in %s: %s", e.Line, e.File, e.Reason)
+}
+
+// Sequence ids of the GFF file that are missing in the Fasta file
+type MissingSeqIdError struct {
+	SeqIds []string
+}
+
+func (e *MissingSeqIdError) Error() string {
+	return "sequence id(s) not found in the Fasta file: " + strings.Join(e.SeqIds, ", ")
+}
+
+// Error that occurred while processing a given locus
+type LocusError struct {
+	Locus string
+	Err   error
+}
+
+func (e *LocusError) Error() string {
+	return fmt.Sprintf("locus %s: %s", e.Locus, e.Err.Error())
+}
+
+func (e *LocusError) Unwrap() error {
+	return e.Err
+}
@@ -2,10 +2,14 @@ package gesyntek
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -24,23 +28,54 @@ const (
 	KMER_LEN   int    = 8
 	GFF_TARGET string = "gene"
 	GFF_ID     string = "ID"
+	// Maximal distance between two matching flanks (classification). Its
+	// scale depends on the distance method: it is not set by default and
+	// must be given with crossed comparisons.
+	CROSS_THRESHOLD float64 = -1
+)
+
+// Handling of loci with an unknown strand (neither '+' nor '-')
+const (
+	STRAND_BOTH    string = "both"    // Compare both orientations and keep the better one
+	STRAND_EXCLUDE string = "exclude" // Skip the locus
 )
 
 // Structure
 type GeSynteK struct {
-	WindowLen      int
-	KmerLen        int
-	Loci           []Locus
-	SeqIdLoci      map[string][]int
-	GffTarget      string
-	GffId          string
-	DistCpt        kmer.KDist
-	DistMethod     string
-	DistValues     [][]float64
-	DistMap        [][]int
-	DistDigit      int
-	NeedMerge      bool
-	IsStandardized bool
+	WindowLen       int
+	KmerLen         int
+	Loci            []Locus
+	SeqIdLoci       map[string][]int
+	GffTarget       string
+	GffId           string
+	DistCpt         kmer.KDist
+	AllPairs        []*FlankMatrix // All-pairs distances (see GramMatrix)
+	GramMatrix      bool           // All-pairs distances from a Gram matrix (if supported by the method)
+	DistMethod      string
+	DistValues      [][]float64
+	DistMap         [][]int
+	DistDigit       int
+	DistPseudoCount float64
+	MarkovOrder     int
+	NeedMerge       bool
+	Sparse          bool // Distances computed from unmerged sparse profiles
+	IsStandardized  bool
+	Norm            kmer.Normalizer
+	Canonical       bool
+	CrossFlank      bool
+	CrossThreshold  float64
+	SoftMask        bool
+	MaskTypes       map[string]bool
+	Intergenic      bool
+	MaskRegions     map[string][][2]int
+	NCachedLoci     int
+	KnownDist       map[[2]string][]float64
+	KnownFlip       map[[2]string]bool
+	UnknownStrand   string
+	DistFlipped     []bool
+	CountsFormat    string // Format of written counts: tsv (default), long or mtx
+	Logger          *slog.Logger
+	Progress        *kmer.ProgressReporter
 }
 
 // Init. GeSynteK object
@@ -56,15 +91,82 @@ func NewGeSynteK(w int, k int, t string, i string, m string, d int) *GeSynteK {
 	gsk.GffId = i
 	gsk.DistMethod = m
 	gsk.DistDigit = d
+	gsk.DistPseudoCount = kmer.DefaultPseudoCount
+	gsk.MarkovOrder = kmer.DefaultMarkovOrder
 	gsk.NeedMerge = false
 	if k > kmer.MaxKSmall {
 		gsk.NeedMerge = true
 	}
 	gsk.IsStandardized = false
+	gsk.Canonical = false
+	gsk.CrossFlank = false
+	gsk.CrossThreshold = CROSS_THRESHOLD
+	gsk.SoftMask = false
+	gsk.MaskTypes = make(map[string]bool)
+	gsk.Intergenic = false
+	gsk.MaskRegions = make(map[string][][2]int)
+	gsk.NCachedLoci = 0
+	gsk.UnknownStrand = STRAND_BOTH
 
 	return &gsk
 }
 
+// GFF feature types that describe sequences rather than features
+var structuralTypes = map[string]bool{
+	"region":      true,
+	"chromosome":  true,
+	"contig":      true,
+	"scaffold":    true,
+	"supercontig": true,
+}
+
+// Set GFF feature types masked in flanks before counting. In intergenic
+// mode, every feature (but structural ones) is masked.
+func (gsk *GeSynteK) SetFeatureMask(types []string, intergenic bool) {
+	gsk.MaskTypes = make(map[string]bool)
+	for _, t := range types {
+		gsk.MaskTypes[t] = true
+	}
+	gsk.Intergenic = intergenic
+}
+
+// Description of the feature mask (stored in cache headers)
+func (gsk *GeSynteK) FeatureMask() string {
+	if gsk.Intergenic {
+		return "intergenic"
+	}
+	types := make([]string, 0, len(gsk.MaskTypes))
+	for t := range gsk.MaskTypes {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return strings.Join(types, ",")
+}
+
+func (gsk *GeSynteK) isMaskedFeature(t string) bool {
+	if gsk.Intergenic {
+		return !structuralTypes[t]
+	}
+	return gsk.MaskTypes[t]
+}
+
+// Sort regions by start and merge overlapping ones
+func mergeRegions(r [][2]int) [][2]int {
+	slices.SortFunc(r, func(a, b [2]int) int {
+		return a[0] - b[0]
+	})
+	merged := make([][2]int, 0, len(r))
+	for _, x := range r {
+		n := len(merged)
+		if n > 0 && x[0] <= merged[n-1][1]+1 {
+			merged[n-1][1] = max(merged[n-1][1], x[1])
+		} else {
+			merged = append(merged, x)
+		}
+	}
+	return merged
+}
+
 // Load data from gff files
 func (gsk *GeSynteK) LoadGFF(gff string) error {
 	// Create the file handler
@@ -77,95 +179,409 @@ func (gsk *GeSynteK) LoadGFF(gff string) error {
 	// Create the buffer
 	fb := bufio.NewScanner(fh)
 
-	// Running variable
-	iloc := 0
+	// Running variables (new loci are appended after existing ones)
+	iloc := len(gsk.Loci)
+	iline := 0
+
+	// Locus names must be unique (including cached loci in append mode)
+	labels := make(map[string]bool)
+	for i := range gsk.Loci {
+		labels[gsk.Loci[i].SeqLabel] = true
+	}
 
 	// Initialize the regex to get the locus name
-	re := regexp.MustCompile(gsk.GffId + "=([\\w\\-\\.]+)")
+	re, err := regexp.Compile(gsk.GffId + "=([\\w\\-\\.]+)")
+	if err != nil {
+		return err
+	}
 
 	// Scan each line of the GFF file
+	gsk.Progress.Start("load loci", 0)
 	for fb.Scan() {
-		err = fb.Err()
+		// Read line, skip comments and empty lines
+		line := fb.Text()
+		iline++
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		elem := strings.Split(line, "\t")
+		if len(elem) < 9 {
+			return &GFFLineError{gff, iline, "expected 9 tab-separated columns"}
+		}
+
+		// Check line target (and features to mask)
+		isTarget := elem[2] == gsk.GffTarget
+		isMasked := gsk.isMaskedFeature(elem[2])
+		if !isTarget && !isMasked {
+			continue
+		}
+
+		// Convert location into integer
+		start, err := strconv.Atoi(elem[3])
 		if err != nil {
-			return err
+			return &GFFLineError{gff, iline, "invalid start position"}
+		}
+		end, err := strconv.Atoi(elem[4])
+		if err != nil {
+			return &GFFLineError{gff, iline, "invalid end position"}
+		}
+		if start < 1 || end < start {
+			return &GFFLineError{gff, iline, "invalid feature coordinates"}
 		}
 
-		// Read line
-		line := fb.Text()
-		elem := strings.Split(line, "\t")
+		if isMasked {
+			gsk.MaskRegions[elem[0]] = append(gsk.MaskRegions[elem[0]], [2]int{start, end})
+		}
 
-		// Check line target
-		if elem[2] == gsk.GffTarget {
+		if isTarget {
 			// Retrieve the name of the locus
 			ln := re.FindStringSubmatch(elem[8])
 			if len(ln) == 0 {
-				return errors.New("failed to retrieve locus name in the GFF file")
+				return &GFFLineError{gff, iline, "failed to retrieve locus name (" + gsk.GffId + ")"}
 			}
 
-			// Convert location into integer
-			start, err := strconv.Atoi(elem[3])
-			if err != nil {
-				return err
+			if labels[ln[1]] {
+				return &GFFLineError{gff, iline, "duplicated locus name " + ln[1]}
 			}
-			end, err := strconv.Atoi(elem[4])
-			if err != nil {
-				return err
+			labels[ln[1]] = true
+
+			if !isStranded(elem[6]) && gsk.UnknownStrand == STRAND_EXCLUDE {
+				if gsk.Logger != nil {
+					gsk.Logger.Warn("locus excluded", "locus", ln[1], "reason", "unknown strand '"+elem[6]+"'")
+				}
+				continue
 			}
 
 			// Create a locus and append
 			gsk.Loci = append(gsk.Loci, *NewLocus(elem[0], ln[1], start, end, elem[6]))
 			gsk.SeqIdLoci[elem[0]] = append(gsk.SeqIdLoci[elem[0]], iloc)
 			iloc++
-		} // Else, do nothing
+		}
 	}
 
-	// Return no error
-	return nil
+	// Sort and merge overlapping regions to mask
+	for id := range gsk.MaskRegions {
+		gsk.MaskRegions[id] = mergeRegions(gsk.MaskRegions[id])
+	}
+	gsk.Progress.End(len(gsk.Loci) - gsk.NCachedLoci)
+
+	return fb.Err()
 }
 
 // Load up and down stream sequences
 func (gsk *GeSynteK) LoadFasta(fasta string) error {
+	return gsk.LoadFastaContext(context.Background(), fasta)
+}
+
+// Load up and down stream sequences (stops if the context is canceled)
+func (gsk *GeSynteK) LoadFastaContext(ctx context.Context, fasta string) error {
 	// Open the fasta file
 	seqIn := seqio.NewReader(fasta, "fasta", false)
-	seqIn.CheckPanic()
+	err := kmer.CheckSeqIO(seqIn.CheckPanic)
+	if err != nil {
+		return err
+	}
 	defer seqIn.Close()
 
+	found := make(map[string]bool)
+	nSeq := 0
+	gsk.Progress.Start("scan sequences", 0)
 	for seqIn.Next() {
-		seqIn.CheckPanic()
+		err = kmer.CheckSeqIO(seqIn.CheckPanic)
+		if err != nil {
+			return err
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
 		seq := seqIn.Seq()
 
 		if inds, ok := gsk.SeqIdLoci[seq.Id]; ok {
+			found[seq.Id] = true
 			for i := 0; i < len(inds); i++ {
-				gsk.Loci[inds[i]].ExtractUpDownSequence(&seq, gsk.WindowLen)
+				err = gsk.Loci[inds[i]].ExtractUpDownSequenceMasked(&seq, gsk.WindowLen, gsk.MaskRegions[seq.Id])
+				if err != nil {
+					return &LocusError{gsk.Loci[inds[i]].SeqLabel, err}
+				}
 			}
 		}
+		nSeq++
+		gsk.Progress.Update(nSeq)
+	}
+	gsk.Progress.End(nSeq)
+
+	// Check that all loci were found
+	missing := make([]string, 0)
+	for id := range gsk.SeqIdLoci {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return &MissingSeqIdError{missing}
 	}
 	return nil
 }
 
-// Count Kmers in up and down stream sequences
+// Count Kmers in up and down stream sequences (cached loci are skipped)
 func (gsk *GeSynteK) CountKmers() error {
-	for i := range len(gsk.Loci) {
-		err := gsk.Loci[i].CountUpDownKmers(gsk.KmerLen)
+	return gsk.CountKmersContext(context.Background())
+}
+
+// Count Kmers in up and down stream sequences (stops if the context is canceled)
+func (gsk *GeSynteK) CountKmersContext(ctx context.Context) error {
+	gsk.Progress.Start("count kmers", len(gsk.Loci)-gsk.NCachedLoci)
+	for i := gsk.NCachedLoci; i < len(gsk.Loci); i++ {
+		err := ctx.Err()
 		if err != nil {
 			return err
 		}
+		err = gsk.Loci[i].CountUpDownKmers(gsk.KmerLen, gsk.Canonical, gsk.SoftMask)
+		if err != nil {
+			return &LocusError{gsk.Loci[i].SeqLabel, err}
+		}
+		gsk.Progress.Update(i - gsk.NCachedLoci + 1)
 	}
+	gsk.Progress.End(len(gsk.Loci) - gsk.NCachedLoci)
 	return nil
 }
 
+// Options corresponding to distance parameters
+var distParamOption = map[string]string{
+	"K":           "KmerLen",
+	"PseudoCount": "DistPseudoCount",
+	"MarkovOrder": "MarkovOrder",
+	"Canonical":   "Canonical",
+}
+
+// Initialize distance computing class according to method (any registered
+// name or alias, case-insensitive)
+func (gsk *GeSynteK) newKDist() (kmer.KDist, error) {
+	m, err := kmer.LookupKDist(gsk.DistMethod)
+	if err != nil {
+		return nil, err
+	}
+	p := kmer.NewKDistParams(gsk.KmerLen)
+	p.PseudoCount = gsk.DistPseudoCount
+	p.MarkovOrder = gsk.MarkovOrder
+	p.Canonical = gsk.Canonical
+	kd, err := m.New(p)
+	var pe *kmer.KDistParamError
+	if errors.As(err, &pe) {
+		return nil, &OptionError{distParamOption[pe.Param], pe.Reason}
+	}
+	return kd, err
+}
+
+// Check if some loci have an unknown strand and are compared in both
+// orientations
+func (gsk *GeSynteK) HasUnstranded() bool {
+	if gsk.UnknownStrand != STRAND_BOTH {
+		return false
+	}
+	for i := range len(gsk.Loci) {
+		if !gsk.Loci[i].IsStranded() {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if reverse complemented counts are required
+func (gsk *GeSynteK) NeedRevComp() bool {
+	return gsk.CrossFlank || gsk.HasUnstranded()
+}
+
+// Mean of the available distances (-1 if none)
+func meanDistance(d ...float64) float64 {
+	sum := 0.0
+	n := 0
+	for _, v := range d {
+		if v > -0.5 {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return -1
+	}
+	return sum / float64(n)
+}
+
+// Number of distance values per pair of loci
+func (gsk *GeSynteK) nDistValues() int {
+	return len(gsk.distColumns())
+}
+
+// Names of the distance columns of the pairwise table: direct, crossed
+// (optional) and reverse distances (asymmetric methods only)
+func (gsk *GeSynteK) distColumns() []string {
+	cols := []string{"Upstream.Distance", "Downstream.Distance"}
+	if gsk.CrossFlank {
+		cols = append(cols, "UpDown.Distance", "DownUp.Distance")
+	}
+	if gsk.isAsymmetric() {
+		cols = append(cols, "Upstream.Reverse.Distance", "Downstream.Reverse.Distance")
+	}
+	return cols
+}
+
+// Check if distances can be computed from sparse profiles, without
+// merging kmer labels (the normalization must keep null counts null)
+func (gsk *GeSynteK) CanUseSparse() bool {
+	kd, err := gsk.newKDist()
+	if err != nil {
+		return false
+	}
+	_, ok := kd.(kmer.KDistSparse)
+	return ok && kmer.PreservesZeros(gsk.Norm)
+}
+
+// Check if the distance between two loci does not depend on the other
+// loci: counts are not merged (they cover all kmers), or the method and
+// the normalization ignore the null counts added by merging. Otherwise,
+// the distances of a previous run cannot be reused when appending loci.
+func (gsk *GeSynteK) HasStableDistances() bool {
+	return !gsk.NeedMerge || gsk.CanUseSparse()
+}
+
+// Check if the distance method is asymmetric
+func (gsk *GeSynteK) isAsymmetric() bool {
+	kd, err := gsk.newKDist()
+	if err != nil {
+		return false
+	}
+	_, ok := kd.(kmer.KDistAsymmetric)
+	return ok
+}
+
+// Distance between two flank counts and the reverse distance (equal for
+// symmetric methods), -1 if one flank is missing
+func (gsk *GeSynteK) flankDistance(has bool, a kmer.KCount, b kmer.KCount) (float64, float64, error) {
+	if !has {
+		return -1, -1, nil
+	}
+	if d, ok := gsk.allPairsDistance(a, b); ok {
+		return d, d, nil
+	}
+	var err error
+	if kd, ok := gsk.DistCpt.(kmer.KDistSparse); ok && gsk.Sparse {
+		err = kd.ComputeProfiles(kmer.NewKProfile(a), kmer.NewKProfile(b))
+	} else {
+		// Both counts share the same (merged) labels
+		if kd, ok := gsk.DistCpt.(kmer.KDistLabeled); ok {
+			kd.SetKmers(a.GetKmers())
+		}
+		if kd, ok := gsk.DistCpt.(kmer.KDistSkipping); ok && a.IsCanonical() {
+			kd.SetKmersToSkip(a.GetKmersToSkip())
+		}
+		err = gsk.DistCpt.Compute(a.GetCounts(), b.GetCounts())
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if kd, ok := gsk.DistCpt.(kmer.KDistAsymmetric); ok {
+		return kd.GetDistance(), kd.GetReverseDistance(), nil
+	}
+	return gsk.DistCpt.GetDistance(), gsk.DistCpt.GetDistance(), nil
+}
+
+// All-pairs distances between flank counts sharing the same labels
+type FlankMatrix struct {
+	Index map[kmer.KCount]int
+	Dist  *mat.SymDense
+}
+
+// Compute all-pairs distances at once (with a single Gram matrix) if
+// requested, the method allows it and counts are merged
+func (gsk *GeSynteK) computeAllPairs() {
+	gsk.AllPairs = nil
+	kd, ok := gsk.DistCpt.(kmer.KDistAllPairs)
+	if !ok || !gsk.GramMatrix || gsk.Sparse {
+		return
+	}
+
+	// Same comparison groups as when merging kmers (crossed ones compare
+	// flanks against reverse complemented counts)
+	var up, down, upCross, downCross []kmer.KCount
+	for i := range len(gsk.Loci) {
+		if gsk.Loci[i].HasUpStr {
+			up = append(up, gsk.Loci[i].KmerUpStr)
+			upCross = append(upCross, gsk.Loci[i].crossUpKmers())
+		}
+		if gsk.Loci[i].HasDownStr {
+			down = append(down, gsk.Loci[i].KmerDownStr)
+			downCross = append(downCross, gsk.Loci[i].crossDownKmers())
+		}
+	}
+	groups := [][]kmer.KCount{up, down}
+	if gsk.NeedRevComp() {
+		for i := range len(gsk.Loci) {
+			if gsk.Loci[i].KmerDownRc != nil {
+				upCross = append(upCross, gsk.Loci[i].KmerDownRc)
+			}
+			if gsk.Loci[i].KmerUpRc != nil {
+				downCross = append(downCross, gsk.Loci[i].KmerUpRc)
+			}
+		}
+		groups = append(groups, upCross, downCross)
+	}
+
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		var fm FlankMatrix
+		fm.Index = make(map[kmer.KCount]int)
+		cnt := make([]*mat.Dense, len(g))
+		for i := range g {
+			fm.Index[g[i]] = i
+			cnt[i] = g[i].GetCounts()
+		}
+		m, err := kmer.StackCounts(cnt)
+		if err != nil {
+			// Counts are not merged: compare pairs one by one
+			gsk.AllPairs = nil
+			return
+		}
+		fm.Dist = kd.ComputeAll(m)
+		gsk.AllPairs = append(gsk.AllPairs, &fm)
+	}
+}
+
+// Distance between two flank counts from all-pairs distances
+func (gsk *GeSynteK) allPairsDistance(a kmer.KCount, b kmer.KCount) (float64, bool) {
+	for _, fm := range gsk.AllPairs {
+		i, okA := fm.Index[a]
+		j, okB := fm.Index[b]
+		if okA && okB {
+			return fm.Dist.At(i, j), true
+		}
+	}
+	return 0, false
+}
+
+// Create reverse complemented counts of each locus flank (required by
+// cross-flank comparisons and unknown-strand loci, before merging kmers)
+func (gsk *GeSynteK) RevCompKmers() {
+	for i := range len(gsk.Loci) {
+		gsk.Loci[i].RevCompUpDownKmers()
+	}
+}
+
 // Compute Kmers distance
 func (gsk *GeSynteK) ComputeKmerDistance() error {
-	// Initialize distance computing class according to method
-	switch gsk.DistMethod {
-	case "Euclidean":
-		gsk.DistCpt = kmer.NewKDistEuclidean()
-	case "Cosine":
-		gsk.DistCpt = kmer.NewKDistCosine()
-	case "Mash":
-		gsk.DistCpt = kmer.NewKDistMash(gsk.KmerLen)
-	default:
-		return errors.New("unsupported kmer distance method")
+	return gsk.ComputeKmerDistanceContext(context.Background())
+}
+
+// Compute Kmers distance (stops if the context is canceled)
+func (gsk *GeSynteK) ComputeKmerDistanceContext(ctx context.Context) error {
+	var err error
+	gsk.DistCpt, err = gsk.newKDist()
+	if err != nil {
+		return err
 	}
 
 	// Initialize distance attributes
@@ -180,38 +596,101 @@ func (gsk *GeSynteK) ComputeKmerDistance() error {
 	}
 	gsk.DistValues = make([][]float64, nDist)
 	gsk.DistMap = make([][]int, nDist)
+	gsk.DistFlipped = make([]bool, nDist)
+	nVal := gsk.nDistValues()
+	nRev := 0
+	if _, ok := gsk.DistCpt.(kmer.KDistAsymmetric); ok {
+		nRev = 2
+	}
+	needRc := gsk.NeedRevComp()
+	if needRc {
+		for i := range nLoci {
+			if (gsk.Loci[i].HasUpStr && gsk.Loci[i].KmerUpRc == nil) ||
+				(gsk.Loci[i].HasDownStr && gsk.Loci[i].KmerDownRc == nil) {
+				return errors.New("missing reverse complemented counts of " + gsk.Loci[i].SeqLabel)
+			}
+		}
+	}
+
+	gsk.computeAllPairs()
 
 	// Set up
 	z := 0
+	gsk.Progress.Start("compute distances", nDist)
 	for i := 0; i < iMax; i++ {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
 		for j := i + jMin; j < nLoci; j++ {
-			gsk.DistValues[z] = make([]float64, 2)
+			gsk.DistValues[z] = make([]float64, nVal)
 			gsk.DistMap[z] = make([]int, 2)
 			gsk.DistMap[z][0] = i
 			gsk.DistMap[z][1] = j
-			// Compute up-stream kmer distance
-			if gsk.Loci[i].HasUpStr && gsk.Loci[j].HasUpStr {
-				err := gsk.DistCpt.Compute(gsk.Loci[i].KmerUpStr.GetCounts(), gsk.Loci[j].KmerUpStr.GetCounts())
+			// Reuse known distances between cached loci
+			if j < gsk.NCachedLoci && gsk.KnownDist != nil {
+				dist, ok := gsk.KnownDist[[2]string{gsk.Loci[i].SeqLabel, gsk.Loci[j].SeqLabel}]
+				if !ok {
+					return errors.New("missing distance between " + gsk.Loci[i].SeqLabel +
+						" and " + gsk.Loci[j].SeqLabel + " in the previous pairwise table")
+				}
+				copy(gsk.DistValues[z], dist)
+				gsk.DistFlipped[z] = gsk.KnownFlip[[2]string{gsk.Loci[i].SeqLabel, gsk.Loci[j].SeqLabel}]
+				z++
+				continue
+			}
+			// Compute up- and down-stream kmer distances
+			li := &gsk.Loci[i]
+			lj := &gsk.Loci[j]
+			d := make([]float64, 4)
+			r := make([]float64, 4)
+			d[0], r[0], err = gsk.flankDistance(li.HasUpStr && lj.HasUpStr, li.KmerUpStr, lj.KmerUpStr)
+			if err != nil {
+				return err
+			}
+			d[1], r[1], err = gsk.flankDistance(li.HasDownStr && lj.HasDownStr, li.KmerDownStr, lj.KmerDownStr)
+			if err != nil {
+				return err
+			}
+			// Compute crossed distances: up(i) against the reverse
+			// complemented down(j) and conversely
+			unknown := gsk.UnknownStrand == STRAND_BOTH && (!li.IsStranded() || !lj.IsStranded())
+			if gsk.CrossFlank || unknown {
+				d[2], r[2], err = gsk.flankDistance(li.HasUpStr && lj.HasDownStr, li.crossUpKmers(), lj.KmerDownRc)
 				if err != nil {
 					return err
 				}
-				gsk.DistValues[z][0] = gsk.DistCpt.GetDistance()
-			} else {
-				gsk.DistValues[z][0] = -1
-			}
-			// Compute down-stream kmer distance
-			if gsk.Loci[i].HasDownStr && gsk.Loci[j].HasDownStr {
-				err := gsk.DistCpt.Compute(gsk.Loci[i].KmerDownStr.GetCounts(), gsk.Loci[j].KmerDownStr.GetCounts())
+				d[3], r[3], err = gsk.flankDistance(li.HasDownStr && lj.HasUpStr, li.crossDownKmers(), lj.KmerUpRc)
 				if err != nil {
 					return err
 				}
-				gsk.DistValues[z][1] = gsk.DistCpt.GetDistance()
-			} else {
-				gsk.DistValues[z][1] = -1
 			}
+			// Flipping a locus of unknown strand turns crossed distances
+			// into direct ones: keep the better orientation. Flipping j
+			// compares up(i) with rc(down(j)), flipping i (only if j is
+			// stranded) compares rc(down(i)) with up(j), i.e. down(i)
+			// with rc(up(j)).
+			if unknown {
+				direct := meanDistance(d[0], d[1])
+				flipped := meanDistance(d[2], d[3])
+				if flipped > -0.5 && (direct < -0.5 || flipped < direct) {
+					if lj.IsStranded() {
+						d[0], d[1], d[2], d[3] = d[3], d[2], d[1], d[0]
+						r[0], r[1], r[2], r[3] = r[3], r[2], r[1], r[0]
+					} else {
+						d[0], d[1], d[2], d[3] = d[2], d[3], d[0], d[1]
+						r[0], r[1], r[2], r[3] = r[2], r[3], r[0], r[1]
+					}
+					gsk.DistFlipped[z] = true
+				}
+			}
+			n := copy(gsk.DistValues[z], d[:nVal-nRev])
+			copy(gsk.DistValues[z][n:], r[:nRev])
 			z++
+			gsk.Progress.Update(z)
 		}
 	}
+	gsk.Progress.End(z)
 
 	return nil
 }
@@ -219,25 +698,116 @@ func (gsk *GeSynteK) ComputeKmerDistance() error {
 // Write out up- and down-stream sequences
 func (gsk *GeSynteK) WriteUpDownFasta(ob string) error {
 	upOut := seqio.NewWriter(ob+"_UpStream.fasta", "fasta", false)
+	err := kmer.CheckSeqIO(upOut.CheckPanic)
+	if err != nil {
+		return err
+	}
 	doOut := seqio.NewWriter(ob+"_DownStream.fasta", "fasta", false)
-	defer upOut.Close()
-	defer doOut.Close()
+	err = kmer.CheckSeqIO(doOut.CheckPanic)
+	if err != nil {
+		upOut.Close()
+		return err
+	}
 
-	for i := range len(gsk.Loci) {
+	// Cached loci have no sequence
+	for i := gsk.NCachedLoci; i < len(gsk.Loci) && err == nil; i++ {
 		if gsk.Loci[i].HasUpStr {
 			upOut.Write(gsk.Loci[i].SeqUpStr)
+			err = kmer.CheckSeqIO(upOut.CheckPanic)
 		}
-		if gsk.Loci[i].HasDownStr {
+		if gsk.Loci[i].HasDownStr && err == nil {
 			doOut.Write(gsk.Loci[i].SeqDownStr)
+			err = kmer.CheckSeqIO(doOut.CheckPanic)
 		}
 	}
+	upOut.Close()
+	doOut.Close()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	// Closing flushes the buffers
+	err = kmer.CheckSeqIO(upOut.CheckPanic)
+	if err != nil {
+		return err
+	}
+	return kmer.CheckSeqIO(doOut.CheckPanic)
+}
+
+// Name of the pairwise distance file
+func (gsk *GeSynteK) PairwiseFile(ob string) string {
+	return ob + "_Pairwise_" + gsk.DistMethod + ".tsv"
+}
+
+// Load distances from a previous pairwise table (NA are stored as -1)
+func (gsk *GeSynteK) LoadPairwiseDistance(file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fb := bufio.NewScanner(fh)
+	gsk.KnownDist = make(map[[2]string][]float64)
+	gsk.KnownFlip = make(map[[2]string]bool)
+
+	// Check the normalization method (comment lines) and locate the
+	// columns from the header
+	norm := "none"
+	for {
+		if !fb.Scan() {
+			return errors.New("empty pairwise distance file")
+		}
+		line := fb.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "# normalization: "); ok {
+			norm = v
+		}
+	}
+	if norm != gsk.NormName() {
+		return errors.New("the previous pairwise table was computed on " + norm +
+			" counts (current normalization: " + gsk.NormName() + ")")
+	}
+	header := strings.Split(fb.Text(), "\t")
+	cols := gsk.distColumns()
+	idx := make([]int, len(cols))
+	for i := range cols {
+		idx[i] = slices.Index(header, cols[i])
+		if idx[i] < 0 {
+			return errors.New("missing column " + cols[i] + " in the pairwise distance file")
+		}
+	}
+	iOri := slices.Index(header, "Orientation")
+
+	for fb.Scan() {
+		elem := strings.Split(fb.Text(), "\t")
+		if len(elem) < len(header) {
+			return errors.New("invalid line in the pairwise distance file")
+		}
+		dist := make([]float64, len(cols))
+		for i := range cols {
+			if elem[idx[i]] == "NA" {
+				dist[i] = -1
+			} else {
+				dist[i], err = strconv.ParseFloat(elem[idx[i]], 64)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		gsk.KnownDist[[2]string{elem[0], elem[1]}] = dist
+		if iOri >= 0 {
+			gsk.KnownFlip[[2]string{elem[0], elem[1]}] = elem[iOri] == "flipped"
+		}
+	}
+	return fb.Err()
 }
 
 // Write out distance between each pair of Loci
 func (gsk *GeSynteK) WritePairwiseDistance(ob string) error {
-	f, err := os.Create(ob + "_Pairwise_" + gsk.DistMethod + ".tsv")
+	f, err := os.Create(gsk.PairwiseFile(ob))
 	if err != nil {
 		return err
 	}
@@ -247,53 +817,144 @@ func (gsk *GeSynteK) WritePairwiseDistance(ob string) error {
 
 	fs := "%.0" + fmt.Sprint(gsk.DistDigit) + "f"
 
-	fw.WriteString("First.Locus\tSecond.Locus\tUpstream.Distance\tDownstream.Distance\n")
+	gsk.writeNormComment(fw)
+	fw.WriteString("First.Locus\tSecond.Locus\t" + strings.Join(gsk.distColumns(), "\t"))
+	if gsk.CrossFlank {
+		fw.WriteString("\tClass")
+	}
+	unknown := gsk.HasUnstranded()
+	if unknown {
+		fw.WriteString("\tOrientation")
+	}
+	fw.WriteByte('\n')
 	for i := range len(gsk.DistValues) {
-		c1 := "NA"
-		if gsk.DistValues[i][0] > -0.5 {
-			c1 = fmt.Sprintf(fs, gsk.DistValues[i][0])
+		fmt.Fprintf(fw, "%s\t%s", gsk.Loci[gsk.DistMap[i][0]].SeqLabel, gsk.Loci[gsk.DistMap[i][1]].SeqLabel)
+		for _, d := range gsk.DistValues[i] {
+			if d > -0.5 {
+				fmt.Fprintf(fw, "\t"+fs, d)
+			} else {
+				fw.WriteString("\tNA")
+			}
 		}
-		c2 := "NA"
-		if gsk.DistValues[i][1] > -0.5 {
-			c2 = fmt.Sprintf(fs, gsk.DistValues[i][1])
+		if gsk.CrossFlank {
+			fw.WriteString("\t" + ClassifyPair(gsk.DistValues[i], gsk.CrossThreshold))
+		}
+		if unknown {
+			if gsk.DistFlipped[i] {
+				fw.WriteString("\tflipped")
+			} else {
+				fw.WriteString("\tdirect")
+			}
 		}
-		fmt.Fprintf(fw, "%s\t%s\t%s\t%s\n", gsk.Loci[gsk.DistMap[i][0]].SeqLabel,
-			gsk.Loci[gsk.DistMap[i][1]].SeqLabel, c1, c2)
+		fw.WriteByte('\n')
 	}
-	fw.Flush()
-
-	return nil
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
 }
 
-func (gsk *GeSynteK) WriteKmerCounts(ob string) error {
-	// Two files, one for upstream kmers and one for downstream
-	fup, err := os.Create(ob + "_UpStream_KmerCounts.tsv")
+// Write out the number of bases skipped in each flank
+func (gsk *GeSynteK) WriteLocusStats(ob string) error {
+	f, err := os.Create(ob + "_LocusStats.tsv")
 	if err != nil {
 		return err
 	}
-	defer fup.Close()
-	fdo, err := os.Create(ob + "_DownStream_KmerCounts.tsv")
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	fw.WriteString("Locus")
+	for _, side := range []string{"Upstream", "Downstream"} {
+		for _, col := range []string{"Degenerated", "SoftMasked", "FeatureMasked", "TooShort"} {
+			fw.WriteString("\t" + side + "." + col)
+		}
+	}
+	fw.WriteByte('\n')
+	for i := range len(gsk.Loci) {
+		fw.WriteString(gsk.Loci[i].SeqLabel)
+		has := []bool{gsk.Loci[i].HasUpStr, gsk.Loci[i].HasDownStr}
+		featMask := []int{gsk.Loci[i].FeatMaskUpStr, gsk.Loci[i].FeatMaskDownStr}
+		for j, kc := range []kmer.KCount{gsk.Loci[i].KmerUpStr, gsk.Loci[i].KmerDownStr} {
+			// Flanks dropped because fully skipped still have statistics
+			if kc == nil || !has[j] && kc.GetSkippedBases() == 0 {
+				fw.WriteString("\tNA\tNA\tNA\tNA")
+			} else {
+				// Feature-masked bases are counted as degenerated by the counter
+				fmt.Fprintf(fw, "\t%d\t%d\t%d\t%d", kc.GetSkippedDegeneratedBases()-featMask[j],
+					kc.GetSkippedMaskedBases(), featMask[j], kc.GetSkippedTooShortBases())
+			}
+		}
+		fw.WriteByte('\n')
+	}
+	err = fw.Flush()
 	if err != nil {
 		return err
 	}
-	defer fdo.Close()
+	return f.Close()
+}
 
-	fupw := bufio.NewWriter(fup)
-	fdow := bufio.NewWriter(fdo)
+// Written kmer label, in canonical mode the label is followed by its
+// reverse complement and nil is returned if the kmer is not canonical
+// (shared by all count formats)
+func (gsk *GeSynteK) kmerLabel(lab []byte) []byte {
+	if !gsk.Canonical {
+		return lab
+	}
+	rc := revComp(lab)
+	if bytes.Compare(rc, lab) < 0 {
+		return nil
+	}
+	out := make([]byte, 0, 2*len(lab)+1)
+	out = append(out, lab...)
+	out = append(out, '/')
+	return append(out, rc...)
+}
 
-	// Create and write the header
-	header := "Kmers"
-	nLoci := len(gsk.Loci)
-	for i := range nLoci {
-		header = header + "\t" + gsk.Loci[i].SeqLabel
+// Sparse view of flank kmer counts (loci without this flank have no
+// counts)
+func (gsk *GeSynteK) countTable(lab [][]byte, val [][]float64) *kmer.KCountTable {
+	var t kmer.KCountTable
+	t.NRows = len(lab)
+	t.Label = func(i int) []byte { return gsk.kmerLabel(lab[i]) }
+	t.Samples = make([]string, len(gsk.Loci))
+	t.Counts = make([][]float64, len(gsk.Loci))
+	for j := range gsk.Loci {
+		t.Samples[j] = gsk.Loci[j].SeqLabel
+		if len(val[j]) > 0 {
+			t.Counts[j] = val[j]
+		}
 	}
-	fupw.WriteString(header + "\n")
-	fdow.WriteString(header + "\n")
+	if gsk.Norm != nil {
+		t.Norm = gsk.Norm.Name()
+	}
+	return &t
+}
+
+func (gsk *GeSynteK) WriteKmerCounts(ob string) error {
+	if len(gsk.Loci) == 0 {
+		return ErrNoLocus
+	}
+	err := kmer.CheckCountsFormat(gsk.CountsFormat)
+	if err != nil {
+		return err
+	}
+
+	nLoci := len(gsk.Loci)
 
 	// Convert kmer numerical ids (uint64) into bytes
+	// (labels are shared by all loci with a flank once merged)
 	kl := kmer.NewKLabel(gsk.KmerLen)
 	labUpInt := gsk.Loci[0].KmerUpStr.GetKmers()
 	labDownInt := gsk.Loci[0].KmerDownStr.GetKmers()
+	for i := nLoci - 1; i >= 0; i-- {
+		if gsk.Loci[i].HasUpStr {
+			labUpInt = gsk.Loci[i].KmerUpStr.GetKmers()
+		}
+		if gsk.Loci[i].HasDownStr {
+			labDownInt = gsk.Loci[i].KmerDownStr.GetKmers()
+		}
+	}
 	nUpKmers := len((*labUpInt)[0])
 	nDownKmers := len((*labDownInt)[0])
 	labUpByte := make([][]byte, nUpKmers)
@@ -328,13 +989,51 @@ func (gsk *GeSynteK) WriteKmerCounts(ob string) error {
 		}
 	}
 
+	// Sparse formats
+	if gsk.CountsFormat == kmer.CountsFormatLong || gsk.CountsFormat == kmer.CountsFormatMTX {
+		err = gsk.countTable(labUpByte, upVal).Write(ob+"_UpStream_KmerCounts", gsk.CountsFormat)
+		if err != nil {
+			return err
+		}
+		return gsk.countTable(labDownByte, doVal).Write(ob+"_DownStream_KmerCounts", gsk.CountsFormat)
+	}
+
+	// Two files, one for upstream kmers and one for downstream
+	fup, err := os.Create(ob + "_UpStream_KmerCounts.tsv")
+	if err != nil {
+		return err
+	}
+	defer fup.Close()
+	fdo, err := os.Create(ob + "_DownStream_KmerCounts.tsv")
+	if err != nil {
+		return err
+	}
+	defer fdo.Close()
+
+	fupw := bufio.NewWriter(fup)
+	fdow := bufio.NewWriter(fdo)
+
+	// Create and write the header (with the normalization method)
+	gsk.writeNormComment(fupw)
+	gsk.writeNormComment(fdow)
+	header := "Kmers"
+	for i := range nLoci {
+		header = header + "\t" + gsk.Loci[i].SeqLabel
+	}
+	fupw.WriteString(header + "\n")
+	fdow.WriteString(header + "\n")
+
 	// Write count values
 	numFmt := "\t%.0f"
 	if gsk.IsStandardized {
 		numFmt = "\t%.04f"
 	}
 	for i := range nUpKmers {
-		fupw.Write(labUpByte[i])
+		lab := gsk.kmerLabel(labUpByte[i])
+		if lab == nil {
+			continue
+		}
+		fupw.Write(lab)
 		for j := range nLoci {
 			if gsk.Loci[j].HasUpStr {
 				fmt.Fprintf(fupw, numFmt, upVal[j][i])
@@ -345,7 +1044,11 @@ func (gsk *GeSynteK) WriteKmerCounts(ob string) error {
 		fupw.WriteByte('\n')
 	}
 	for i := range nDownKmers {
-		fdow.Write(labDownByte[i])
+		lab := gsk.kmerLabel(labDownByte[i])
+		if lab == nil {
+			continue
+		}
+		fdow.Write(lab)
 		for j := range nLoci {
 			if gsk.Loci[j].HasDownStr {
 				fmt.Fprintf(fdow, numFmt, doVal[j][i])
@@ -355,66 +1058,146 @@ func (gsk *GeSynteK) WriteKmerCounts(ob string) error {
 		}
 		fdow.WriteByte('\n')
 	}
-	fupw.Flush()
-	fdow.Flush()
-
-	return nil
+	err = fupw.Flush()
+	if err != nil {
+		return err
+	}
+	err = fdow.Flush()
+	if err != nil {
+		return err
+	}
+	err = fdo.Close()
+	if err != nil {
+		return err
+	}
+	return fup.Close()
 }
 
 // Standardize counts
 func (gsk *GeSynteK) StandardizeCounts() {
+	gsk.NormalizeCounts(kmer.NewKNormZScore())
+}
+
+// Normalize counts (after merging kmers)
+func (gsk *GeSynteK) NormalizeCounts(n kmer.Normalizer) {
 	for i := range len(gsk.Loci) {
-		if gsk.Loci[i].HasUpStr {
-			kmer.Standardize(gsk.Loci[i].KmerUpStr.GetCounts())
-		}
-		if gsk.Loci[i].HasDownStr {
-			kmer.Standardize(gsk.Loci[i].KmerDownStr.GetCounts())
+		for _, kc := range []kmer.KCount{gsk.Loci[i].KmerUpStr, gsk.Loci[i].KmerDownStr, gsk.Loci[i].KmerUpRc, gsk.Loci[i].KmerDownRc,
+			gsk.Loci[i].KmerUpCross, gsk.Loci[i].KmerDownCross} {
+			if kc != nil {
+				n.Normalize(kc.GetCounts())
+			}
 		}
 	}
+	gsk.Norm = n
 	gsk.IsStandardized = true
 }
 
+// Name of the normalization method ("none" for raw counts)
+func (gsk *GeSynteK) NormName() string {
+	if gsk.Norm == nil {
+		return "none"
+	}
+	return gsk.Norm.Name()
+}
+
+// Write the normalization method as a comment line (raw counts are
+// not commented)
+func (gsk *GeSynteK) writeNormComment(w *bufio.Writer) {
+	if gsk.Norm != nil {
+		w.WriteString("# normalization: " + gsk.Norm.Name() + "\n")
+	}
+}
+
+// Copy of a counter (its counts are added to an empty one)
+func (gsk *GeSynteK) copyKmers(kc kmer.KCount) (kmer.KCount, error) {
+	cp, err := kmer.NewKCount(gsk.KmerLen, gsk.Canonical)
+	if err != nil {
+		return nil, err
+	}
+	err = cp.AddCounts(kc)
+	return cp, err
+}
+
 // Merge Kmer label for each counts
 func (gsk *GeSynteK) MergeKmers() error {
-	if gsk.NeedMerge {
-		// First compute union of kmer labels
-		var UpLab [][]uint64
-		var DoLab [][]uint64
+	if gsk.NeedMerge && !gsk.Sparse {
+		gsk.Progress.Start("merge kmers", len(gsk.Loci))
+		defer gsk.Progress.End(len(gsk.Loci))
+
+		// Empty set of kmer labels
+		var nWords int
 		if gsk.KmerLen <= kmer.MaxK64Bits {
-			UpLab = make([][]uint64, 1)
-			UpLab[0] = make([]uint64, 0)
-			DoLab = make([][]uint64, 1)
-			DoLab[0] = make([]uint64, 0)
+			nWords = 1
 		} else if gsk.KmerLen <= kmer.MaxK128Bits {
-			UpLab = make([][]uint64, 2)
-			UpLab[0] = make([]uint64, 0)
-			UpLab[1] = make([]uint64, 0)
-			DoLab = make([][]uint64, 2)
-			DoLab[0] = make([]uint64, 0)
-			DoLab[1] = make([]uint64, 0)
+			nWords = 2
 		} else {
 			return errors.New("unsupported kmer value (too high) for merging")
 		}
-		kl := kmer.NewKLabel(gsk.KmerLen)
-		for i := range len(gsk.Loci) {
-			if gsk.Loci[i].HasUpStr {
-				kl.MergeUint64(&UpLab, gsk.Loci[i].KmerUpStr.GetKmers())
+		newLab := func() [][]uint64 {
+			lab := make([][]uint64, nWords)
+			for w := range nWords {
+				lab[w] = make([]uint64, 0)
 			}
-			if gsk.Loci[i].HasDownStr {
-				kl.MergeUint64(&DoLab, gsk.Loci[i].KmerDownStr.GetKmers())
+			return lab
+		}
+
+		// Crossed comparisons (and loci of unknown strand) compare each
+		// flank against the reverse complement of the other one: they
+		// use copies of the flank counts so that direct comparisons keep
+		// their own labels
+		needRc := gsk.NeedRevComp()
+		if needRc {
+			for i := range len(gsk.Loci) {
+				var err error
+				if gsk.Loci[i].HasUpStr {
+					gsk.Loci[i].KmerUpCross, err = gsk.copyKmers(gsk.Loci[i].KmerUpStr)
+					if err != nil {
+						return err
+					}
+				}
+				if gsk.Loci[i].HasDownStr {
+					gsk.Loci[i].KmerDownCross, err = gsk.copyKmers(gsk.Loci[i].KmerDownStr)
+					if err != nil {
+						return err
+					}
+				}
 			}
 		}
 
-		// Scan each counts and insert missing label (with zero-count)
+		// Comparison groups: up- and down-stream flanks, then upstream
+		// flanks against reverse complemented downstream ones and
+		// conversely
+		groups := make([][]kmer.KCount, 2)
 		for i := range len(gsk.Loci) {
 			if gsk.Loci[i].HasUpStr {
-				err := gsk.Loci[i].KmerUpStr.MergeKmers(&UpLab)
-				if err != nil {
-					return err
-				}
+				groups[0] = append(groups[0], gsk.Loci[i].KmerUpStr)
 			}
 			if gsk.Loci[i].HasDownStr {
-				err := gsk.Loci[i].KmerDownStr.MergeKmers(&DoLab)
+				groups[1] = append(groups[1], gsk.Loci[i].KmerDownStr)
+			}
+		}
+		if needRc {
+			groups = append(groups, make([]kmer.KCount, 0), make([]kmer.KCount, 0))
+			for i := range len(gsk.Loci) {
+				for g, kc := range []kmer.KCount{gsk.Loci[i].KmerUpCross, gsk.Loci[i].KmerDownRc,
+					gsk.Loci[i].KmerDownCross, gsk.Loci[i].KmerUpRc} {
+					if kc != nil {
+						groups[2+g/2] = append(groups[2+g/2], kc)
+					}
+				}
+			}
+		}
+
+		// First compute union of kmer labels of each group, then insert
+		// missing labels in each counts (with zero-count)
+		kl := kmer.NewKLabel(gsk.KmerLen)
+		for _, g := range groups {
+			lab := newLab()
+			for _, kc := range g {
+				kl.MergeUint64(&lab, kc.GetKmers())
+			}
+			for _, kc := range g {
+				err := kc.MergeKmers(&lab)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,308 @@
+package gesyntek
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that canonical kmers give null distances between a locus and a
+// locus whose flanks are reverse complemented
+func TestCanonical(t *testing.T) {
+	// Flanks of 2000 bp around a gene of 1000 bp (2501-3500)
+	r := rand.New(rand.NewPCG(1, 2))
+	random := func(n int) []byte {
+		dna := make([]byte, n)
+		for i := range dna {
+			dna[i] = "ACGT"[r.IntN(4)]
+		}
+		return dna
+	}
+	pad, left, gene, right := random(500), random(2000), random(1000), random(2000)
+	join := func(parts ...[]byte) string {
+		return string(bytes.Join(parts, nil))
+	}
+	fasta := filepath.Join(t.TempDir(), "loci.fasta")
+	err := os.WriteFile(fasta, []byte(">CHR_A\n"+join(pad, left, gene, right, pad)+
+		"\n>CHR_B\n"+join(pad, revComp(left), gene, revComp(right), pad)+"\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to write the Fasta file: %s", err.Error())
+	}
+	gff := writeGFF(t, "CHR_A\t.\tgene\t2501\t3500\t.\t+\t.\tID=GENE_A",
+		"CHR_B\t.\tgene\t2501\t3500\t.\t+\t.\tID=GENE_B")
+
+	for _, k := range []int{6, 10} {
+		for _, c := range []bool{false, true} {
+			opt := testOptions(t)
+			opt.Gff = gff
+			opt.Fasta = fasta
+			opt.KmerLen = k
+			opt.Canonical = c
+			gsk := runPipeline(t, opt)
+			d := gsk.DistValues[0]
+			if c && (math.Abs(d[0]) > 1e-9 || math.Abs(d[1]) > 1e-9) {
+				t.Errorf("Expected null canonical distances for K=%d but found %f and %f.", k, d[0], d[1])
+			}
+			if !c && (d[0] < 1e-6 || d[1] < 1e-6) {
+				t.Errorf("Expected non-null distances without canonical kmers for K=%d but found %f and %f.", k, d[0], d[1])
+			}
+		}
+	}
+}
+
+// Test that loci of unknown strand are compared in the better orientation
+// or excluded
+func TestUnknownStrand(t *testing.T) {
+	// GENE_02 on both strands and without strand
+	lines := exampleGFF(t)
+	gff := writeGFF(t, lines[1],
+		"CHR_02\t.\tgene\t69004\t70800\t.\t.\t.\tID=GENE_02U",
+		"CHR_02\t.\tgene\t69004\t70800\t.\t-\t.\tID=GENE_02R")
+
+	for _, k := range []int{6, 10} {
+		opt := testOptions(t)
+		opt.Gff = gff
+		opt.KmerLen = k
+		opt.DistMethod = "Cosine"
+		opt.Normalize = "zscore"
+		gsk := runPipeline(t, opt)
+		if !gsk.HasUnstranded() {
+			t.Fatalf("Expected loci of unknown strand.")
+		}
+
+		// The unknown-strand locus matches GENE_02 directly and GENE_02R
+		// once flipped; stranded loci are never flipped
+		flipped := []bool{false, false, true}
+		for z := range gsk.DistValues {
+			if gsk.DistFlipped[z] != flipped[z] {
+				t.Errorf("Expected pair %v to be flipped %t for K=%d.", gsk.DistMap[z], flipped[z], k)
+			}
+		}
+		for _, z := range []int{0, 2} {
+			d := gsk.DistValues[z]
+			if math.Abs(d[0]) > 1e-9 || math.Abs(d[1]) > 1e-9 {
+				t.Errorf("Expected null distances for pair %v (K=%d) but found %f and %f.", gsk.DistMap[z], k, d[0], d[1])
+			}
+		}
+		if d := gsk.DistValues[1]; d[0] < 1e-6 || d[1] < 1e-6 {
+			t.Errorf("Expected non-null distances between opposite strands (K=%d) but found %f and %f.", k, d[0], d[1])
+		}
+
+		data, err := os.ReadFile(gsk.PairwiseFile(opt.OutputBase))
+		if err != nil {
+			t.Fatalf("Failed to read the pairwise table: %s", err.Error())
+		}
+		rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if !strings.HasSuffix(rows[1], "\tOrientation") || !strings.HasSuffix(rows[4], "\tflipped") ||
+			!strings.HasSuffix(rows[2], "\tdirect") {
+			t.Errorf("Unexpected orientation column in the pairwise table:\n%s", data)
+		}
+
+		// Excluded loci
+		opt.UnknownStrand = STRAND_EXCLUDE
+		gsk = runPipeline(t, opt)
+		if len(gsk.Loci) != 2 || gsk.Loci[1].SeqLabel != "GENE_02R" {
+			t.Fatalf("Expected the unknown-strand locus to be excluded.")
+		}
+		if gsk.HasUnstranded() || gsk.DistFlipped[0] {
+			t.Errorf("Expected no pair compared in both orientations.")
+		}
+		data, err = os.ReadFile(gsk.PairwiseFile(opt.OutputBase))
+		if err != nil {
+			t.Fatalf("Failed to read the pairwise table: %s", err.Error())
+		}
+		if strings.Contains(string(data), "Orientation") {
+			t.Errorf("Expected no orientation column without unknown-strand loci.")
+		}
+	}
+}
+
+// Test that sparse count tables hold the same counts as the dense table
+// and list loci without a flank
+func TestWriteKmerCounts(t *testing.T) {
+	for _, c := range []bool{false, true} {
+		opt := testOptions(t)
+		opt.WindowLen = WINDOW_LEN
+		opt.KmerLen = 10
+		opt.Canonical = c
+		opt.WriteCounts = true
+		gsk := runPipeline(t, opt)
+		missing := ""
+		for i := range gsk.Loci {
+			if !gsk.Loci[i].HasUpStr {
+				missing = gsk.Loci[i].SeqLabel
+			}
+		}
+		if missing == "" {
+			t.Fatalf("Expected a locus without upstream flank.")
+		}
+
+		// Non-null counts of the dense table (loci without flank are NA)
+		data, err := os.ReadFile(opt.OutputBase + "_UpStream_KmerCounts.tsv")
+		if err != nil {
+			t.Fatalf("Failed to read the count table: %s", err.Error())
+		}
+		rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+		header := strings.Split(rows[0], "\t")
+		dense := make(map[string]bool)
+		nRows := 0
+		for _, row := range rows[1:] {
+			elem := strings.Split(row, "\t")
+			nRows++
+			for j := 1; j < len(elem); j++ {
+				if header[j] == missing && elem[j] != "NA" {
+					t.Fatalf("Expected NA counts for %s but found %s.", missing, elem[j])
+				}
+				if elem[j] != "0" && elem[j] != "NA" {
+					dense[elem[0]+"\t"+header[j]+"\t"+elem[j]] = true
+				}
+			}
+		}
+
+		// Long format
+		gsk.CountsFormat = "long"
+		err = gsk.WriteKmerCounts(opt.OutputBase)
+		if err != nil {
+			t.Fatalf("Failed to write sparse counts: %s", err.Error())
+		}
+		data, err = os.ReadFile(opt.OutputBase + "_UpStream_KmerCounts_Long.tsv")
+		if err != nil {
+			t.Fatalf("Failed to read the long count table: %s", err.Error())
+		}
+		rows = strings.Split(strings.TrimSpace(string(data)), "\n")
+		if rows[0] != "# no counts: "+missing || rows[1] != "Kmer\tSample\tCount" {
+			t.Errorf("Expected %s to be listed without counts but found header %q.", missing, rows[:2])
+		}
+		if len(rows)-2 != len(dense) {
+			t.Errorf("Expected %d count lines (canonical: %t) but found %d.", len(dense), c, len(rows)-2)
+		}
+		for _, row := range rows[2:] {
+			if !dense[row] {
+				t.Fatalf("Unexpected count line %q (canonical: %t).", row, c)
+			}
+		}
+
+		// Matrix Market format
+		gsk.CountsFormat = "mtx"
+		err = gsk.WriteKmerCounts(opt.OutputBase)
+		if err != nil {
+			t.Fatalf("Failed to write sparse counts: %s", err.Error())
+		}
+		data, err = os.ReadFile(opt.OutputBase + "_UpStream_KmerCounts.mtx")
+		if err != nil {
+			t.Fatalf("Failed to read the count matrix: %s", err.Error())
+		}
+		rows = strings.Split(strings.TrimSpace(string(data)), "\n")
+		size := fmt.Sprintf("%d %d %d", nRows, len(gsk.Loci), len(dense))
+		if rows[1] != "% no counts: "+missing || rows[2] != size {
+			t.Errorf("Expected %s to be listed without counts and size %q but found %q.", missing, size, rows[1:3])
+		}
+		data, err = os.ReadFile(opt.OutputBase + "_UpStream_KmerCounts_Cols.tsv")
+		if err != nil || !strings.Contains(string(data), missing+"\n") {
+			t.Errorf("Expected %s in the column labels.", missing)
+		}
+	}
+}
+
+// Test that a flipped locus of unknown strand gives the distances of the
+// same locus on the other strand, whichever locus of the pair comes first
+func TestUnknownStrandOrder(t *testing.T) {
+	// A locus close to GENE_02 but on the other strand: GENE_02U is
+	// flipped when compared with it
+	other := "CHR_02\t.\tgene\t69104\t70900\t.\t-\t.\tID=GENE_OT"
+	unknown := "CHR_02\t.\tgene\t69004\t70800\t.\t.\t.\tID=GENE_02U"
+	reverse := "CHR_02\t.\tgene\t69004\t70800\t.\t-\t.\tID=GENE_02U"
+	settings := []struct {
+		method string
+		cross  bool
+	}{
+		{"Euclidean", false},
+		{"Euclidean", true},
+		{"Containment", false},
+	}
+	for _, s := range settings {
+		for _, first := range []bool{true, false} {
+			lines := []string{unknown, other}
+			expected := []string{reverse, other}
+			if !first {
+				lines[0], lines[1] = lines[1], lines[0]
+				expected[0], expected[1] = expected[1], expected[0]
+			}
+			opt := testOptions(t)
+			opt.DistMethod = s.method
+			opt.CrossFlank = s.cross
+			opt.CrossThreshold = 0.5
+			opt.Gff = writeGFF(t, lines...)
+			gsk := runPipeline(t, opt)
+			if !gsk.DistFlipped[0] {
+				t.Fatalf("Expected GENE_02U to be flipped (%s, first: %t).", s.method, first)
+			}
+			found := readPairwise(t, gsk.PairwiseFile(opt.OutputBase))
+
+			opt.Gff = writeGFF(t, expected...)
+			opt.OutputBase = filepath.Join(t.TempDir(), "test")
+			gsk = runPipeline(t, opt)
+			want := readPairwise(t, gsk.PairwiseFile(opt.OutputBase))
+			for c, v := range want {
+				if math.Abs(found[c]-v) > 1e-4 {
+					t.Errorf("Expected %f in column %d (%s, cross: %t, first: %t) but found %f.",
+						v, c, s.method, s.cross, first, found[c])
+				}
+			}
+		}
+	}
+}
+
+// Distance values of the first pair of a pairwise table
+func readPairwise(t *testing.T, file string) []float64 {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read the pairwise table: %s", err.Error())
+	}
+	rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+	i := 0
+	for strings.HasPrefix(rows[i], "#") || strings.HasPrefix(rows[i], "First.Locus") {
+		i++
+	}
+	var d []float64
+	for _, v := range strings.Split(rows[i], "\t")[2:] {
+		var f float64
+		_, err := fmt.Sscan(v, &f)
+		if err == nil {
+			d = append(d, f)
+		}
+	}
+	return d
+}
+
+// Test that all-pairs distances from a Gram matrix are only computed on
+// request and match pairwise distances
+func TestGramMatrix(t *testing.T) {
+	for _, method := range []string{"Euclidean", "Cosine"} {
+		opt := testOptions(t)
+		opt.DistMethod = method
+		opt.CrossFlank = true
+		opt.CrossThreshold = 0.5
+		pairwise := runPipeline(t, opt)
+		if pairwise.AllPairs != nil {
+			t.Errorf("Expected pairwise %s distances by default.", method)
+		}
+		opt.GramMatrix = true
+		gram := runPipeline(t, opt)
+		if len(gram.AllPairs) != 4 {
+			t.Fatalf("Expected all-pairs %s distances of 4 flank groups but found %d.", method, len(gram.AllPairs))
+		}
+		for z := range pairwise.DistValues {
+			for c, v := range pairwise.DistValues[z] {
+				if math.Abs(gram.DistValues[z][c]-v) > 1e-9*max(1, v) {
+					t.Errorf("Expected %s distance %f for pair %v but found %f.", method, v, pairwise.DistMap[z], gram.DistValues[z][c])
+				}
+			}
+		}
+	}
+}
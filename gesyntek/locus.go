@@ -3,6 +3,7 @@ package gesyntek
 import (
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/hdevillers/go-gesyntek/kmer"
 	"github.com/hdevillers/go-seq/seq"
@@ -25,6 +26,16 @@ type Locus struct {
 	HasDownStr  bool
 	KmerUpStr   kmer.KCount
 	KmerDownStr kmer.KCount
+	// Reverse complemented counts (cross-flank comparison)
+	KmerUpRc   kmer.KCount
+	KmerDownRc kmer.KCount
+	// Copies of the flank counts compared against reverse complemented
+	// counts, once merged with them (nil if counts are not merged)
+	KmerUpCross   kmer.KCount
+	KmerDownCross kmer.KCount
+	// Number of bases masked by annotated features
+	FeatMaskUpStr   int
+	FeatMaskDownStr int
 }
 
 // Constructor
@@ -39,38 +50,95 @@ func NewLocus(i string, l string, s int, e int, p string) *Locus {
 	locus.SeqStrand = p
 	locus.HasUpStr = false
 	locus.HasDownStr = false
+	locus.FeatMaskUpStr = 0
+	locus.FeatMaskDownStr = 0
 
 	return &locus
 }
 
+// Check the strand is known ('+' or '-')
+func isStranded(p string) bool {
+	return p == "+" || p == "-"
+}
+
+func (locus *Locus) IsStranded() bool {
+	return isStranded(locus.SeqStrand)
+}
+
+// Complement table (unsupported characters are turned into N)
+var complement = func() []byte {
+	c := make([]byte, 256)
+	for i := range c {
+		c[i] = 'N'
+	}
+	for _, p := range []string{"AT", "CG", "GC", "TA", "NN", "at", "cg", "gc", "ta", "nn"} {
+		c[p[0]] = p[1]
+	}
+	return c
+}()
+
+// Reverse complement a DNA sequence
+func revComp(dna []byte) []byte {
+	n := len(dna)
+	rc := make([]byte, n)
+	for i := range n {
+		rc[n-i-1] = complement[dna[i]]
+	}
+	return rc
+}
+
+// Replace bases of dna (starting at the 1-based position from) that fall
+// into the sorted regions by N. Returns the number of masked bases.
+func maskRegions(dna []byte, from int, regions [][2]int) int {
+	to := from + len(dna) - 1
+	n := 0
+
+	// First region that may overlap
+	i, _ := slices.BinarySearchFunc(regions, from, func(r [2]int, p int) int {
+		return r[1] - p
+	})
+	for ; i < len(regions) && regions[i][0] <= to; i++ {
+		for p := max(regions[i][0], from); p <= min(regions[i][1], to); p++ {
+			dna[p-from] = 'N'
+			n++
+		}
+	}
+	return n
+}
+
 // Extract up- and down-stream sequences
 func (locus *Locus) ExtractUpDownSequence(s *seq.Seq, w int) error {
+	return locus.ExtractUpDownSequenceMasked(s, w, nil)
+}
+
+// Extract up- and down-stream sequences, bases that fall into the sorted
+// and non-overlapping regions are masked (replaced by N). Loci of unknown
+// strand are extracted in the forward orientation.
+func (locus *Locus) ExtractUpDownSequenceMasked(s *seq.Seq, w int, regions [][2]int) error {
+	if locus.SeqEnd > s.Length() {
+		return errors.New("locus coordinates exceed the sequence length")
+	}
+
 	// Extract sub-sequence on the 'left'
 	if locus.SeqStart > w {
 		leftDNA := make([]byte, w)
 		from := locus.SeqStart - w - 1
 		to := locus.SeqStart - 1
 		copy(leftDNA, s.Sequence[from:to])
+		nMask := maskRegions(leftDNA, from+1, regions)
 
-		if locus.SeqStrand == "+" {
+		if locus.SeqStrand != "-" {
 			// This is up-stream sequence
 			locus.HasUpStr = true
+			locus.FeatMaskUpStr = nMask
 			id := fmt.Sprintf("%s_upstream_w%d", locus.SeqId, w)
 			locus.SeqUpStr = seq.Seq{Id: id, Sequence: leftDNA}
 		} else {
 			// This is down-stream and sequence has to be rev-comp
-			leftDNArc := make([]byte, w) // To be included into go-seq module!
-			rc := make([]byte, 85)
-			rc[65] = 'T'
-			rc[67] = 'G'
-			rc[71] = 'C'
-			rc[84] = 'A'
-			for i := range w {
-				leftDNArc[w-i-1] = rc[leftDNA[i]]
-			}
 			locus.HasDownStr = true
+			locus.FeatMaskDownStr = nMask
 			id := fmt.Sprintf("%s_downstream_w%d", locus.SeqId, w)
-			locus.SeqDownStr = seq.Seq{Id: id, Sequence: leftDNArc}
+			locus.SeqDownStr = seq.Seq{Id: id, Sequence: revComp(leftDNA)}
 		}
 	} // Else nothing to do (or create an empty sequence with w*N?)
 
@@ -80,59 +148,83 @@ func (locus *Locus) ExtractUpDownSequence(s *seq.Seq, w int) error {
 		from := locus.SeqEnd
 		to := from + w
 		copy(rightDNA, s.Sequence[from:to])
+		nMask := maskRegions(rightDNA, from+1, regions)
 
-		if locus.SeqStrand == "+" {
+		if locus.SeqStrand != "-" {
 			// This is down-stream sequence
 			locus.HasDownStr = true
+			locus.FeatMaskDownStr = nMask
 			id := fmt.Sprintf("%s_downstream_w%d", locus.SeqId, w)
 			locus.SeqDownStr = seq.Seq{Id: id, Sequence: rightDNA}
 		} else {
 			// This is up-stream and sequence has to be rev-comp
-			rightDNArc := make([]byte, w)
-			rc := make([]byte, 85)
-			rc[65] = 'T'
-			rc[67] = 'G'
-			rc[71] = 'C'
-			rc[84] = 'A'
-			for i := range w {
-				rightDNArc[w-i-1] = rc[rightDNA[i]]
-			}
 			locus.HasUpStr = true
+			locus.FeatMaskUpStr = nMask
 			id := fmt.Sprintf("%s_upstream_w%d", locus.SeqId, w)
-			locus.SeqUpStr = seq.Seq{Id: id, Sequence: rightDNArc}
+			locus.SeqUpStr = seq.Seq{Id: id, Sequence: revComp(rightDNA)}
 		}
 	} // Else nothing...
 	return nil
 }
 
-// Run Kmer counts
-func (locus *Locus) CountUpDownKmers(K int) error {
-	if K <= kmer.MaxKSmall {
-		locus.KmerUpStr = kmer.NewKCountSmall(K, false)
-		locus.KmerDownStr = kmer.NewKCountSmall(K, false)
-	} else if K <= kmer.MaxK64Bits {
-		locus.KmerUpStr = kmer.NewKCount32(K, false)
-		locus.KmerDownStr = kmer.NewKCount32(K, false)
-	} else if K <= kmer.MaxK128Bits {
-		locus.KmerUpStr = kmer.NewKCount64(K, false)
-		locus.KmerDownStr = kmer.NewKCount64(K, false)
-	} else {
-		return errors.New("value of K is too high (not supported yet)")
+// Run Kmer counts (canonical kmers if c is true, soft-masked bases are
+// skipped if sm is true)
+func (locus *Locus) CountUpDownKmers(K int, c bool, sm bool) error {
+	var err error
+	locus.KmerUpStr, err = kmer.NewKCount(K, c)
+	if err != nil {
+		return err
+	}
+	locus.KmerDownStr, err = kmer.NewKCount(K, c)
+	if err != nil {
+		return err
 	}
+	locus.KmerUpStr.SetSoftMask(sm)
+	locus.KmerDownStr.SetSoftMask(sm)
 
-	// Run counting
+	// Run counting (a flank without any countable kmer is dropped)
 	if locus.HasUpStr {
 		err := locus.KmerUpStr.Count(&locus.SeqUpStr.Sequence)
-		if err != nil {
+		if errors.Is(err, kmer.ErrNoSequenceKept) {
+			locus.HasUpStr = false
+		} else if err != nil {
 			return err
 		}
 	}
 	if locus.HasDownStr {
 		err := locus.KmerDownStr.Count(&locus.SeqDownStr.Sequence)
-		if err != nil {
+		if errors.Is(err, kmer.ErrNoSequenceKept) {
+			locus.HasDownStr = false
+		} else if err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// Create reverse complemented counters of both flanks
+func (locus *Locus) RevCompUpDownKmers() {
+	if locus.HasUpStr {
+		locus.KmerUpRc = locus.KmerUpStr.RevComp()
+	}
+	if locus.HasDownStr {
+		locus.KmerDownRc = locus.KmerDownStr.RevComp()
+	}
+}
+
+// Upstream counts compared against reverse complemented downstream counts
+func (locus *Locus) crossUpKmers() kmer.KCount {
+	if locus.KmerUpCross != nil {
+		return locus.KmerUpCross
+	}
+	return locus.KmerUpStr
+}
+
+// Downstream counts compared against reverse complemented upstream counts
+func (locus *Locus) crossDownKmers() kmer.KCount {
+	if locus.KmerDownCross != nil {
+		return locus.KmerDownCross
+	}
+	return locus.KmerDownStr
+}
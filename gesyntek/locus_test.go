@@ -0,0 +1,93 @@
+package gesyntek
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// Test that overlapping and adjacent regions are merged
+func TestMergeRegions(t *testing.T) {
+	regions := [][2]int{{50, 60}, {1, 10}, {5, 20}, {21, 30}, {40, 45}, {55, 58}}
+	expected := [][2]int{{1, 30}, {40, 45}, {50, 60}}
+	merged := mergeRegions(regions)
+	if !slices.Equal(merged, expected) {
+		t.Errorf("Expected merged regions %v but found %v.", expected, merged)
+	}
+	if len(mergeRegions(nil)) != 0 {
+		t.Errorf("Expected no merged region.")
+	}
+}
+
+// Test that only bases falling into regions are masked
+func TestMaskRegions(t *testing.T) {
+	regions := [][2]int{{1, 3}, {8, 9}, {12, 14}, {30, 40}}
+	dna := []byte("ACGTACGTACGTACGT")
+	// Bases 5 to 20 (1-based)
+	n := maskRegions(dna, 5, regions)
+	expected := []byte("ACGNNCGNNNGTACGT")
+	if !bytes.Equal(dna, expected) {
+		t.Errorf("Expected masked sequence %s but found %s.", expected, dna)
+	}
+	if n != 5 {
+		t.Errorf("Expected 5 masked bases but found %d.", n)
+	}
+
+	dna = []byte("ACGTACGT")
+	n = maskRegions(dna, 100, regions)
+	if n != 0 || string(dna) != "ACGTACGT" {
+		t.Errorf("Expected no masked base outside regions but found %d.", n)
+	}
+}
+
+// Test that annotated features are masked in flanks, either by type or
+// all of them (but structural ones) in intergenic mode
+func TestFeatureMask(t *testing.T) {
+	// Flanks of GENE_02 (+ strand) with a 2000 bp window: upstream is
+	// 67004-69003 and downstream 70801-72800
+	gff := writeGFF(t,
+		"CHR_02\t.\tregion\t1\t200000\t.\t+\t.\tID=CHR_02",
+		"CHR_02\t.\tgene\t69004\t70800\t.\t+\t.\tID=GENE_02",
+		"CHR_02\t.\tCDS\t68001\t68500\t.\t+\t.\tID=CDS_01",
+		"CHR_02\t.\tCDS\t68401\t68600\t.\t+\t.\tID=CDS_02",
+		"CHR_02\t.\ttRNA\t70701\t70900\t.\t-\t.\tID=TRNA_01",
+	)
+	settings := []struct {
+		types      []string
+		intergenic bool
+		up, down   int
+	}{
+		{nil, false, 0, 0},
+		{[]string{"CDS"}, false, 600, 0},
+		{[]string{"tRNA"}, false, 0, 100},
+		{nil, true, 600, 100},
+	}
+	for _, s := range settings {
+		gsk := NewGeSynteK(2000, 6, GFF_TARGET, GFF_ID, "Euclidean", 4)
+		gsk.SetFeatureMask(s.types, s.intergenic)
+		err := gsk.LoadGFF(gff)
+		if err != nil {
+			t.Fatalf("Failed to load the GFF file: %s", err.Error())
+		}
+		if len(gsk.Loci) != 1 {
+			t.Fatalf("Expected a single locus but found %d.", len(gsk.Loci))
+		}
+		err = gsk.LoadFasta("../examples/test.fasta")
+		if err != nil {
+			t.Fatalf("Failed to load the Fasta file: %s", err.Error())
+		}
+		locus := &gsk.Loci[0]
+		if locus.FeatMaskUpStr != s.up || locus.FeatMaskDownStr != s.down {
+			t.Errorf("Expected %d/%d masked bases with mask %q but found %d/%d.",
+				s.up, s.down, gsk.FeatureMask(), locus.FeatMaskUpStr, locus.FeatMaskDownStr)
+		}
+		nUp := bytes.Count(locus.SeqUpStr.Sequence[997:1597], []byte("N"))
+		if s.up > 0 && nUp != s.up {
+			t.Errorf("Expected masked CDS bases to be replaced by N but found %d N.", nUp)
+		}
+		nDown := bytes.Count(locus.SeqDownStr.Sequence[:100], []byte("N"))
+		if s.down > 0 && nDown != s.down {
+			t.Errorf("Expected masked tRNA bases to be replaced by N but found %d N.", nDown)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package gesyntek
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/hdevillers/go-gesyntek/kmer"
+)
+
+/*
+	Pipeline class: run all GeSynteK steps from a set of options
+*/
+
+// Pipeline options
+type Options struct {
+	Gff             string // GFF input file (loci description)
+	Fasta           string // Fasta input file
+	GffTarget       string // GFF feature type to target
+	GffId           string // GFF attribute defining the locus ID
+	KmerLen         int
+	WindowLen       int
+	DistMethod      string
+	DistDigit       int
+	DistPseudoCount float64  // Pseudocount of the JensenShannon, JSDivergence and SymKL distances
+	MarkovOrder     int      // Order of the Markov background of the D2S and D2Star distances
+	GramMatrix      bool     // All-pairs Euclidean and Cosine distances from a single matrix product
+	Standardize     bool     // Same as Normalize = "zscore"
+	Normalize       string   // Count normalization: freq, clr, presence or zscore (raw counts if empty)
+	PseudoCount     float64  // Pseudocount of the clr normalization
+	Canonical       bool     // Count canonical kmers (strand-independent comparison)
+	CrossFlank      bool     // Also compare up- against down-stream flanks (reverse complemented)
+	CrossThreshold  float64  // Maximal distance between matching flanks (pair classification, required by CrossFlank)
+	UnknownStrand   string   // Loci of unknown strand: STRAND_BOTH or STRAND_EXCLUDE
+	SoftMask        bool     // Lowercase (soft-masked) bases are not counted
+	MaskFeatures    []string // GFF feature types masked in flanks (e.g. CDS, exon, tRNA)
+	Intergenic      bool     // Mask all annotated features (keep intergenic spacers only)
+	Cache           string   // Binary cache of per-locus kmer counts (optional)
+	Append          bool     // Append the loci of Gff/Fasta to the cached analysis
+	OutputBase      string   // Output base path (nothing is written if empty)
+	WriteFasta      bool
+	WriteCounts     bool
+	CountsFormat    string            // Format of written counts: tsv (default), long or mtx
+	WriteStats      bool              // Write the number of skipped bases per locus
+	Progress        kmer.ProgressFunc // Progress callback, see kmer.ProgressPrinter (optional)
+	Logger          *slog.Logger      // Structured logger, phases are logged at Info level (optional)
+}
+
+// Default options
+func NewOptions() Options {
+	return Options{
+		GffTarget:       GFF_TARGET,
+		GffId:           GFF_ID,
+		KmerLen:         KMER_LEN,
+		WindowLen:       WINDOW_LEN,
+		DistMethod:      "Euclidean",
+		DistDigit:       4,
+		CrossThreshold:  CROSS_THRESHOLD,
+		UnknownStrand:   STRAND_BOTH,
+		PseudoCount:     kmer.DefaultPseudoCount,
+		DistPseudoCount: kmer.DefaultPseudoCount,
+		MarkovOrder:     kmer.DefaultMarkovOrder,
+	}
+}
+
+// Check option values
+func (opt *Options) Validate() error {
+	if opt.Gff == "" {
+		return &OptionError{"Gff", "an input GFF file is required"}
+	}
+	if opt.Fasta == "" {
+		return &OptionError{"Fasta", "an input Fasta file is required"}
+	}
+	if opt.KmerLen < 1 {
+		return &OptionError{"KmerLen", "kmer length must be positive"}
+	}
+	if opt.KmerLen > kmer.MaxKAbsolute {
+		return ErrKTooLarge
+	}
+	if opt.WindowLen < opt.KmerLen {
+		return &OptionError{"WindowLen", "window length must be greater than the kmer length"}
+	}
+	if opt.DistPseudoCount < 0 {
+		return &OptionError{"DistPseudoCount", "pseudocount cannot be negative"}
+	}
+	if opt.DistDigit < 0 {
+		return &OptionError{"DistDigit", "number of digits cannot be negative"}
+	}
+	if opt.CrossFlank && opt.CrossThreshold < 0 {
+		return &OptionError{"CrossThreshold", "a threshold is required by crossed comparisons (its scale depends on the distance method)"}
+	}
+	if opt.UnknownStrand != STRAND_BOTH && opt.UnknownStrand != STRAND_EXCLUDE {
+		return &OptionError{"UnknownStrand", "expected " + STRAND_BOTH + " or " + STRAND_EXCLUDE}
+	}
+	if opt.Standardize && opt.Normalize != "" && opt.Normalize != "zscore" {
+		return &OptionError{"Standardize", "standardization conflicts with the " + opt.Normalize + " normalization"}
+	}
+	if opt.Normalize != "" {
+		_, err := kmer.NewNormalizer(opt.Normalize, opt.PseudoCount)
+		if err != nil {
+			return &OptionError{"Normalize", err.Error()}
+		}
+	}
+	if kmer.CheckCountsFormat(opt.CountsFormat) != nil {
+		return &OptionError{"CountsFormat", "expected " + kmer.CountsFormatTSV + ", " + kmer.CountsFormatLong + " or " + kmer.CountsFormatMTX}
+	}
+	if opt.Append && opt.Cache == "" {
+		return &OptionError{"Append", "append mode requires a cache file"}
+	}
+	if opt.Append && opt.OutputBase == "" {
+		return &OptionError{"Append", "append mode requires the output base of the previous run"}
+	}
+	return nil
+}
+
+type Pipeline struct {
+	Options  Options
+	GeSynteK *GeSynteK
+}
+
+func NewPipeline(opt Options) *Pipeline {
+	var p Pipeline
+	p.Options = opt
+	return &p
+}
+
+// Run all steps: load loci and count kmers (or reuse the cache), compute
+// distances and write outputs
+func (p *Pipeline) Run(ctx context.Context) error {
+	opt := &p.Options
+	err := opt.Validate()
+	if err != nil {
+		return err
+	}
+
+	gsk := NewGeSynteK(opt.WindowLen, opt.KmerLen, opt.GffTarget, opt.GffId, opt.DistMethod, opt.DistDigit)
+	gsk.Progress = kmer.NewProgressReporter(opt.Progress, opt.Logger)
+	gsk.Canonical = opt.Canonical
+	gsk.CrossFlank = opt.CrossFlank
+	gsk.CrossThreshold = opt.CrossThreshold
+	gsk.UnknownStrand = opt.UnknownStrand
+	gsk.CountsFormat = opt.CountsFormat
+	gsk.Logger = opt.Logger
+	gsk.DistPseudoCount = opt.DistPseudoCount
+	gsk.MarkovOrder = opt.MarkovOrder
+	gsk.GramMatrix = opt.GramMatrix
+	if opt.Standardize {
+		gsk.Norm = kmer.NewKNormZScore()
+	} else if opt.Normalize != "" {
+		gsk.Norm, _ = kmer.NewNormalizer(opt.Normalize, opt.PseudoCount)
+	}
+	gsk.SoftMask = opt.SoftMask
+	gsk.SetFeatureMask(opt.MaskFeatures, opt.Intergenic)
+	p.GeSynteK = gsk
+
+	// Check the distance method before counting (outputs are named after
+	// its registered name)
+	m, err := kmer.LookupKDist(gsk.DistMethod)
+	if err != nil {
+		return err
+	}
+	gsk.DistMethod = m.Name
+	_, err = gsk.newKDist()
+	if err != nil {
+		return err
+	}
+
+	// Try to reuse cached counts (sequences are not cached)
+	loaded := false
+	var hdr *CacheHeader
+	if opt.Cache != "" {
+		hdr, err = gsk.NewCacheHeader(opt.Gff, opt.Fasta)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.Append {
+		// Load previous loci and distances, then count only the new loci
+		prev, err := gsk.LoadCacheForAppend(opt.Cache, hdr)
+		if err != nil {
+			return err
+		}
+		// The cache becomes append-only: it holds the checksums of all
+		// input sets and no plain run can match it again
+		hdr.Checksums = append(prev.Checksums, hdr.Checksums...)
+		// Previous distances change with the union of kmer labels for
+		// some methods and normalizations: they are then recomputed
+		if gsk.HasStableDistances() {
+			err = gsk.LoadPairwiseDistance(gsk.PairwiseFile(opt.OutputBase))
+			if err != nil {
+				return err
+			}
+		} else if opt.Logger != nil {
+			opt.Logger.Info("previous distances are recomputed", "reason", "they depend on the kmers of the new loci")
+		}
+	} else if opt.Cache != "" && !opt.WriteFasta {
+		gsk.Progress.Start("load cache", 0)
+		loaded, err = gsk.LoadCache(opt.Cache, hdr)
+		if err != nil {
+			return err
+		}
+		gsk.Progress.End(len(gsk.Loci))
+	}
+
+	if !loaded {
+		err = gsk.LoadGFF(opt.Gff)
+		if err != nil {
+			return err
+		}
+		if len(gsk.Loci) == gsk.NCachedLoci {
+			return ErrNoLocus
+		}
+
+		err = gsk.LoadFastaContext(ctx, opt.Fasta)
+		if err != nil {
+			return err
+		}
+
+		err = gsk.CountKmersContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Save counts for later runs
+		if opt.Cache != "" {
+			err = gsk.WriteCache(opt.Cache, hdr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if gsk.NeedRevComp() {
+		gsk.RevCompKmers()
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	// Sparse profiles avoid the union of all kmer labels (written counts
+	// require it)
+	gsk.Sparse = gsk.NeedMerge && !opt.WriteCounts && gsk.CanUseSparse()
+	err = gsk.MergeKmers()
+	if err != nil {
+		return err
+	}
+	if gsk.Norm != nil {
+		gsk.NormalizeCounts(gsk.Norm)
+	}
+
+	err = gsk.ComputeKmerDistanceContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Write outputs
+	if opt.OutputBase == "" {
+		return nil
+	}
+	if opt.WriteFasta {
+		err = gsk.WriteUpDownFasta(opt.OutputBase)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.WriteCounts {
+		err = gsk.WriteKmerCounts(opt.OutputBase)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.WriteStats {
+		err = gsk.WriteLocusStats(opt.OutputBase)
+		if err != nil {
+			return err
+		}
+	}
+	return gsk.WritePairwiseDistance(opt.OutputBase)
+}
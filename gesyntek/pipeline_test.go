@@ -0,0 +1,286 @@
+package gesyntek
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hdevillers/go-gesyntek/kmer"
+)
+
+// Write GFF lines into a temporary file
+func writeGFF(t *testing.T, lines ...string) string {
+	file := filepath.Join(t.TempDir(), "loci.gff")
+	err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to write the GFF file: %s", err.Error())
+	}
+	return file
+}
+
+// Lines of the example GFF file
+func exampleGFF(t *testing.T) []string {
+	data, err := os.ReadFile("../examples/test.gff")
+	if err != nil {
+		t.Fatalf("Failed to read the example GFF file: %s", err.Error())
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// Test that appending loci gives the same pairwise table as a full run,
+// whether previous distances are reused or recomputed
+func TestAppend(t *testing.T) {
+	lines := exampleGFF(t)
+	settings := []struct {
+		k      int
+		method string
+		norm   string
+		stable bool
+	}{
+		{6, "Euclidean", "zscore", true},
+		{10, "Mash", "", true},
+		{10, "Euclidean", "freq", true},
+		{10, "Euclidean", "zscore", false},
+		{10, "Pearson", "clr", false},
+		{10, "JensenShannon", "", false},
+	}
+	for _, s := range settings {
+		opt := testOptions(t)
+		opt.KmerLen = s.k
+		opt.DistMethod = s.method
+		opt.Normalize = s.norm
+		opt.CrossFlank = true
+		opt.CrossThreshold = 0.5
+
+		// Full run
+		full := opt
+		full.Gff = writeGFF(t, lines...)
+		gsk := runPipeline(t, full)
+		if gsk.HasStableDistances() != s.stable {
+			t.Errorf("Expected stable distances to be %t for %s (K=%d, %q normalization).", s.stable, s.method, s.k, s.norm)
+		}
+
+		// First loci, then the others appended
+		opt.Cache = filepath.Join(t.TempDir(), "test.gskc")
+		opt.OutputBase = filepath.Join(t.TempDir(), "test")
+		opt.Gff = writeGFF(t, lines[:3]...)
+		runPipeline(t, opt)
+		opt.Gff = writeGFF(t, lines[3:]...)
+		opt.Append = true
+		runPipeline(t, opt)
+
+		want, err := os.ReadFile(gsk.PairwiseFile(full.OutputBase))
+		if err != nil {
+			t.Fatalf("Failed to read the pairwise table: %s", err.Error())
+		}
+		found, err := os.ReadFile(gsk.PairwiseFile(opt.OutputBase))
+		if err != nil {
+			t.Fatalf("Failed to read the appended pairwise table: %s", err.Error())
+		}
+		if string(found) != string(want) {
+			t.Errorf("Expected the appended table to match the full run for %s (K=%d, %q normalization) but found:\n%s\ninstead of:\n%s",
+				s.method, s.k, s.norm, found, want)
+		}
+	}
+}
+
+// Test that the pipeline reports typed errors
+func TestPipelineErrors(t *testing.T) {
+	lines := exampleGFF(t)
+
+	// Invalid GFF lines (line numbers include comments)
+	gffErrors := map[string]string{
+		"CHR_01\t.\tgene\t122004\t123743\t.\t-\tID=GENE_01":      "expected 9 tab-separated columns",
+		"CHR_01\t.\tgene\tstart\t123743\t.\t-\t.\tID=GENE_01":    "invalid start position",
+		"CHR_01\t.\tgene\t123743\t122004\t.\t-\t.\tID=GENE_01":   "invalid feature coordinates",
+		"CHR_01\t.\tgene\t122004\t123743\t.\t-\t.\tName=GENE_01": "failed to retrieve locus name (ID)",
+	}
+	for line, reason := range gffErrors {
+		opt := testOptions(t)
+		opt.Gff = writeGFF(t, "##gff-version 3", lines[1], line)
+		err := NewPipeline(opt).Run(context.Background())
+		var ge *GFFLineError
+		if !errors.As(err, &ge) {
+			t.Fatalf("Expected a GFF line error but found %v.", err)
+		}
+		if ge.Line != 3 || ge.Reason != reason || ge.File != opt.Gff {
+			t.Errorf("Expected error %q at line 3 but found %q at line %d.", reason, ge.Reason, ge.Line)
+		}
+	}
+
+	// Duplicated locus names, in the same GFF file or in a GFF file
+	// appended to cached loci
+	reason := "duplicated locus name GENE_02"
+	opt := testOptions(t)
+	opt.Gff = writeGFF(t, lines[1], lines[2], lines[1])
+	err := NewPipeline(opt).Run(context.Background())
+	var ge *GFFLineError
+	if !errors.As(err, &ge) || ge.Line != 3 || ge.Reason != reason {
+		t.Errorf("Expected error %q at line 3 but found %v.", reason, err)
+	}
+	opt.Gff = writeGFF(t, lines[:3]...)
+	opt.Cache = filepath.Join(t.TempDir(), "test.gskc")
+	runPipeline(t, opt)
+	opt.Gff = writeGFF(t, lines[3], lines[1])
+	opt.Append = true
+	err = NewPipeline(opt).Run(context.Background())
+	if !errors.As(err, &ge) || ge.Line != 2 || ge.Reason != reason {
+		t.Errorf("Expected error %q at line 2 of the appended file but found %v.", reason, err)
+	}
+
+	// Sequence ids missing in the Fasta file
+	opt = testOptions(t)
+	opt.Gff = writeGFF(t, lines[0], strings.Replace(lines[1], "CHR_02", "CHR_12", 1),
+		strings.Replace(lines[2], "CHR_03", "CHR_11", 1))
+	err = NewPipeline(opt).Run(context.Background())
+	var me *MissingSeqIdError
+	if !errors.As(err, &me) {
+		t.Fatalf("Expected a missing sequence id error but found %v.", err)
+	}
+	if strings.Join(me.SeqIds, ",") != "CHR_11,CHR_12" {
+		t.Errorf("Expected missing ids CHR_11,CHR_12 but found %v.", me.SeqIds)
+	}
+
+	// Invalid options
+	opt = testOptions(t)
+	opt.KmerLen = kmer.MaxKAbsolute + 1
+	err = NewPipeline(opt).Run(context.Background())
+	if !errors.Is(err, ErrKTooLarge) {
+		t.Errorf("Expected %v but found %v.", ErrKTooLarge, err)
+	}
+	opt = testOptions(t)
+	opt.UnknownStrand = "forward"
+	err = NewPipeline(opt).Run(context.Background())
+	var oe *OptionError
+	if !errors.As(err, &oe) || oe.Option != "UnknownStrand" {
+		t.Errorf("Expected an invalid UnknownStrand option but found %v.", err)
+	}
+	opt = testOptions(t)
+	opt.DistMethod = "TETRA"
+	opt.Canonical = true
+	err = NewPipeline(opt).Run(context.Background())
+	if !errors.As(err, &oe) || oe.Option != "Canonical" {
+		t.Errorf("Expected an invalid Canonical option for TETRA but found %v.", err)
+	}
+	opt = testOptions(t)
+	opt.DistMethod = "Unknown"
+	err = NewPipeline(opt).Run(context.Background())
+	if !errors.Is(err, ErrUnsupportedDistance) {
+		t.Errorf("Expected %v but found %v.", ErrUnsupportedDistance, err)
+	}
+
+	// No locus of the target type
+	opt = testOptions(t)
+	opt.GffTarget = "tRNA"
+	err = NewPipeline(opt).Run(context.Background())
+	if !errors.Is(err, ErrNoLocus) {
+		t.Errorf("Expected %v but found %v.", ErrNoLocus, err)
+	}
+}
+
+// Test that a canceled pipeline stops and writes nothing
+func TestPipelineCancel(t *testing.T) {
+	opt := testOptions(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := NewPipeline(opt)
+	err := p.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected %v but found %v.", context.Canceled, err)
+	}
+	_, err = os.Stat(p.GeSynteK.PairwiseFile(opt.OutputBase))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no pairwise table after cancellation.")
+	}
+
+	// Cancellation while counting
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	opt.Progress = func(ev kmer.Progress) {
+		if ev.Phase == "count kmers" && ev.Done > 0 {
+			cancel()
+		}
+	}
+	err = NewPipeline(opt).Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v while counting but found %v.", context.Canceled, err)
+	}
+}
+
+// Test the progress events and log records of a pipeline run
+func TestPipelineProgress(t *testing.T) {
+	lines := exampleGFF(t)
+	opt := testOptions(t)
+	opt.KmerLen = 10
+	opt.Normalize = "zscore" // Requires merged kmers
+	opt.UnknownStrand = STRAND_EXCLUDE
+	opt.Gff = writeGFF(t, append(lines, "CHR_02\t.\tgene\t69004\t70800\t.\t.\t.\tID=GENE_02U")...)
+	var events []kmer.Progress
+	opt.Progress = func(ev kmer.Progress) {
+		events = append(events, ev)
+	}
+	var buf bytes.Buffer
+	opt.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	runPipeline(t, opt)
+
+	// Each phase starts with no work done, keeps its total and ends once
+	var phases []string
+	for i, ev := range events {
+		if i == 0 || events[i-1].End {
+			if ev.Done != 0 || ev.End {
+				t.Errorf("Expected phase %s to start with no work done but found %+v.", ev.Phase, ev)
+			}
+			phases = append(phases, ev.Phase)
+			continue
+		}
+		if ev.Phase != events[i-1].Phase || ev.Total != events[i-1].Total {
+			t.Errorf("Unexpected event %+v in phase %s.", ev, events[i-1].Phase)
+		}
+		if ev.End && ev.Total > 0 && ev.Done != ev.Total {
+			t.Errorf("Expected phase %s to end with %d done but found %d.", ev.Phase, ev.Total, ev.Done)
+		}
+	}
+	expected := []string{"load loci", "scan sequences", "count kmers", "merge kmers", "compute distances"}
+	if !slices.Equal(phases, expected) || !events[len(events)-1].End {
+		t.Fatalf("Expected phases %v but found %v.", expected, phases)
+	}
+	last := events[len(events)-1]
+	if last.Total != 10 || last.Done != 10 {
+		t.Errorf("Expected 10 pairwise distances but found %d/%d.", last.Done, last.Total)
+	}
+
+	// The same phases are logged, as well as the excluded locus
+	var started, completed []string
+	excluded := ""
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]any
+		err := dec.Decode(&rec)
+		if err != nil {
+			t.Fatalf("Failed to decode a log record: %s", err.Error())
+		}
+		switch rec["msg"] {
+		case "phase started":
+			started = append(started, rec["phase"].(string))
+		case "phase completed":
+			completed = append(completed, rec["phase"].(string))
+		case "locus excluded":
+			if rec["level"] == "WARN" {
+				excluded = rec["locus"].(string)
+			}
+		}
+	}
+	if !slices.Equal(started, expected) || !slices.Equal(completed, expected) {
+		t.Errorf("Expected logged phases %v but found %v and %v.", expected, started, completed)
+	}
+	if excluded != "GENE_02U" {
+		t.Errorf("Expected a warning about the excluded locus GENE_02U.")
+	}
+}
@@ -135,8 +135,9 @@ func (hmd *HeatMapDistance) LoadDistanceFile(fd string) error {
 	keys := make(map[string]bool, 0)
 	hmd.Labels = make([]string, 0)
 
-	// Skip the header
-	fb.Scan()
+	// Skip comment lines and the header
+	for fb.Scan() && strings.HasPrefix(fb.Text(), "#") {
+	}
 	err = fb.Err()
 	if err != nil {
 		return err
@@ -1,6 +1,11 @@
 package kmer
 
-import "gonum.org/v1/gonum/mat"
+import (
+	"errors"
+	"io"
+
+	"gonum.org/v1/gonum/mat"
+)
 
 const (
 	MaxKSmall    int = 8
@@ -11,15 +16,144 @@ const (
 	MaxKAbsolute int = 64
 )
 
+// Error returned when K exceeds the largest supported value
+var ErrKTooLarge = errors.New("value of K is too high (maximal supported value is 64)")
+
 type KCount interface {
 	Count(*[]byte) error
 	MergeKmers(*[][]uint64) error
 	GetSkippedBases() int
 	GetSkippedDegeneratedBases() int
+	GetSkippedMaskedBases() int
 	GetSkippedTooShortBases() int
 	GetCounts() *mat.Dense
 	GetKmers() *[][]uint64
 	GetNKmers() int
+	SetSoftMask(bool)
 	IsCanonical() bool
 	GetKmersToSkip() *[]uint8
+	WriteBinary(io.Writer) error
+	ReadBinary(io.ByteReader) error
+	RevComp() KCount
+	AddCounts(KCount) error
+}
+
+// Error returned when adding counts of incompatible counters
+var ErrIncompatibleCounters = errors.New("cannot add counts of kmer counters with different types, lengths or strand settings")
+
+// Partial counts of successive sequences. Each addition merges the
+// counters of similar sizes (as a binary counter does) so that summing many
+// small counters costs O(n log n) instead of O(n^2).
+type kcountStack []KCount
+
+func (st *kcountStack) push(kc KCount) error {
+	*st = append(*st, kc)
+	for n := len(*st); n > 1 && 2*(*st)[n-1].GetNKmers() >= (*st)[n-2].GetNKmers(); n-- {
+		err := (*st)[n-2].AddCounts((*st)[n-1])
+		if err != nil {
+			return err
+		}
+		*st = (*st)[:n-1]
+	}
+	return nil
+}
+
+// Sum all partial counts (nil if empty)
+func (st *kcountStack) sum() (KCount, error) {
+	for n := len(*st); n > 1; n-- {
+		err := (*st)[n-2].AddCounts((*st)[n-1])
+		if err != nil {
+			return nil, err
+		}
+		*st = (*st)[:n-1]
+	}
+	if len(*st) == 0 {
+		return nil, nil
+	}
+	return (*st)[0], nil
+}
+
+// Sum two sets of sorted labels and counts (the union of labels is
+// returned in new slices)
+func addSortedCounts(ka [][]uint64, ca []float64, kb [][]uint64, cb []float64) ([][]uint64, []float64) {
+	nWords := len(ka)
+	less := func(i, j int) int {
+		for w := range nWords {
+			if ka[w][i] < kb[w][j] {
+				return -1
+			} else if ka[w][i] > kb[w][j] {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	// Size of the union
+	n := 0
+	i, j := 0, 0
+	for i < len(ca) && j < len(cb) {
+		c := less(i, j)
+		if c <= 0 {
+			i++
+		}
+		if c >= 0 {
+			j++
+		}
+		n++
+	}
+	n += len(ca) - i + len(cb) - j
+
+	kout := make([][]uint64, nWords)
+	for w := range nWords {
+		kout[w] = make([]uint64, n)
+	}
+	cout := make([]float64, n)
+	i, j = 0, 0
+	for o := range n {
+		c := 0
+		if i == len(ca) {
+			c = 1
+		} else if j == len(cb) {
+			c = -1
+		} else {
+			c = less(i, j)
+		}
+		if c <= 0 {
+			for w := range nWords {
+				kout[w][o] = ka[w][i]
+			}
+			cout[o] += ca[i]
+			i++
+		}
+		if c >= 0 {
+			for w := range nWords {
+				kout[w][o] = kb[w][j]
+			}
+			cout[o] += cb[j]
+			j++
+		}
+	}
+	return kout, cout
+}
+
+// Reverse complement of a kmer of n bases encoded in a word
+func revCompWord(w uint64, n int) uint64 {
+	rc := uint64(0)
+	for range n {
+		rc = (rc << 2) | (3 - w&3)
+		w >>= 2
+	}
+	return rc
+}
+
+// Create the kmer counter adapted to the value of K
+func NewKCount(K int, c bool) (KCount, error) {
+	if K <= MaxKSmall {
+		return NewKCountSmall(K, c), nil
+	} else if K <= MaxK64Bits {
+		return NewKCount32(K, c), nil
+	} else if K <= MaxK128Bits {
+		return NewKCount64(K, c), nil
+	}
+	return nil, ErrKTooLarge
 }
@@ -1,6 +1,9 @@
 package kmer
 
 import (
+	"cmp"
+	"errors"
+	"io"
 	"slices"
 
 	"gonum.org/v1/gonum/mat"
@@ -15,7 +18,9 @@ type KCount32 struct {
 	Bwd       uint64
 	Kmers     [][]uint64
 	Counts    mat.Dense
+	SoftMask  bool
 	SkipDeg   int
+	SkipMask  int
 	SkipShort int
 }
 
@@ -26,7 +31,9 @@ func NewKCount32(K int, c bool) *KCount32 {
 	kcs.Canonical = c
 	kcs.Fwd = uint64((32 - K + 1) * 2)
 	kcs.Bwd = uint64((32 - K) * 2)
+	kcs.SoftMask = false
 	kcs.SkipDeg = 0
+	kcs.SkipMask = 0
 	kcs.SkipShort = 0
 	kcs.Counts = *mat.NewDense(1, 1, nil)
 	kcs.Kmers = make([][]uint64, 1)
@@ -58,15 +65,17 @@ func NewKCount32(K int, c bool) *KCount32 {
 func (kcs *KCount32) Count(seq *[]byte) error {
 	// Split the input sequence to keep only countable words
 	seqSpl := NewKSplit(kcs.K)
+	seqSpl.SetSoftMask(kcs.SoftMask)
 	err := seqSpl.SplitSeq(seq)
+
+	// Retrieve the number of skipped bases
+	kcs.SkipDeg += seqSpl.NSkipped
+	kcs.SkipMask += seqSpl.NMasked
+	kcs.SkipShort += seqSpl.NTooShort
 	if err != nil {
 		return err
 	}
 
-	// Retrieve the number of skipped bases
-	kcs.SkipDeg = seqSpl.NSkipped
-	kcs.SkipShort = seqSpl.NTooShort
-
 	// Count the number of kmers from seqSpl
 	nKmers := 0
 	for i := range len(seqSpl.SeqSplit) {
@@ -138,12 +147,32 @@ func (kcs *KCount32) Count(seq *[]byte) error {
 		i += cnt
 	}
 
-	// Copy labels and counts
-	kcs.Kmers[0] = make([]uint64, iKmer)
-	copy(kcs.Kmers[0], tmpLab[0:iKmer])
-	kcs.Counts = *mat.NewDense(iKmer, 1, nil)
-	kcs.Counts.SetCol(0, tmpCnt[0:iKmer])
+	// Add labels and counts to the previous ones
+	n := len(kcs.Kmers[0])
+	kmers, cnt := addSortedCounts(kcs.Kmers, mat.Col(nil, 0, &kcs.Counts)[:n], [][]uint64{tmpLab[0:iKmer]}, tmpCnt[0:iKmer])
+	kcs.Kmers[0] = kmers[0]
+	kcs.Counts = *mat.NewDense(len(cnt), 1, cnt)
+
+	return nil
+}
 
+// Add the counts of another counter (union of labels)
+func (kcs *KCount32) AddCounts(o KCount) error {
+	ko, ok := o.(*KCount32)
+	if !ok || ko.K != kcs.K || ko.Canonical != kcs.Canonical {
+		return ErrIncompatibleCounters
+	}
+	kcs.SkipDeg += ko.SkipDeg
+	kcs.SkipMask += ko.SkipMask
+	kcs.SkipShort += ko.SkipShort
+	no := len(ko.Kmers[0])
+	if no == 0 {
+		return nil
+	}
+	n := len(kcs.Kmers[0])
+	kmers, cnt := addSortedCounts(kcs.Kmers, mat.Col(nil, 0, &kcs.Counts)[:n], ko.Kmers, mat.Col(nil, 0, &ko.Counts)[:no])
+	kcs.Kmers[0] = kmers[0]
+	kcs.Counts = *mat.NewDense(len(cnt), 1, cnt)
 	return nil
 }
 
@@ -200,12 +229,16 @@ func (kcs *KCount32) GetSkippedDegeneratedBases() int {
 	return kcs.SkipDeg
 }
 
+func (kcs *KCount32) GetSkippedMaskedBases() int {
+	return kcs.SkipMask
+}
+
 func (kcs *KCount32) GetSkippedTooShortBases() int {
 	return kcs.SkipShort
 }
 
 func (kcs *KCount32) GetSkippedBases() int {
-	return kcs.SkipDeg + kcs.SkipShort
+	return kcs.SkipDeg + kcs.SkipMask + kcs.SkipShort
 }
 
 func (kcs *KCount32) GetKmers() *[][]uint64 {
@@ -220,6 +253,10 @@ func (kcs *KCount32) GetNKmers() int {
 	return len(kcs.Kmers[0])
 }
 
+func (kcs *KCount32) SetSoftMask(m bool) {
+	kcs.SoftMask = m
+}
+
 func (kcs *KCount32) IsCanonical() bool {
 	return kcs.Canonical
 }
@@ -228,3 +265,78 @@ func (kcs *KCount32) GetKmersToSkip() *[]uint8 {
 	out := make([]uint8, kcs.GetNKmers())
 	return &out
 }
+
+func (kcs *KCount32) WriteBinary(w io.Writer) error {
+	// Counts hold a single dummy value when nothing was counted
+	cnt := mat.Col(nil, 0, &kcs.Counts)[:len(kcs.Kmers[0])]
+	err := writeKCountHeader(w, kcountHeader{kind32, kcs.K, kcs.Canonical, kcs.SkipDeg, kcs.SkipMask, kcs.SkipShort, len(cnt)})
+	if err != nil {
+		return err
+	}
+	return writeSortedCounts(w, kcs.Kmers, cnt)
+}
+
+func (kcs *KCount32) ReadBinary(r io.ByteReader) error {
+	h, err := readKCountHeader(r, kind32, kcs.K)
+	if err != nil {
+		return err
+	}
+	if h.canonical != kcs.Canonical {
+		return errors.New("unexpected canonical mode in binary data")
+	}
+	kcs.SkipDeg = h.skipDeg
+	kcs.SkipMask = h.skipMask
+	kcs.SkipShort = h.skipShort
+
+	kmers, cnt, err := readSortedCounts(r, 1, h.n)
+	if err != nil {
+		return err
+	}
+	kcs.Kmers = kmers
+	if h.n == 0 {
+		kcs.Counts = *mat.NewDense(1, 1, nil)
+	} else {
+		kcs.Counts = *mat.NewDense(h.n, 1, cnt)
+	}
+	return nil
+}
+
+// Create a counter of the reverse complemented sequence(s): canonical
+// counts are unchanged
+func (kcs *KCount32) RevComp() KCount {
+	rc := NewKCount32(kcs.K, kcs.Canonical)
+	rc.SoftMask = kcs.SoftMask
+	rc.SkipDeg = kcs.SkipDeg
+	rc.SkipMask = kcs.SkipMask
+	rc.SkipShort = kcs.SkipShort
+
+	n := len(kcs.Kmers[0])
+	rc.Kmers[0] = make([]uint64, n)
+	copy(rc.Kmers[0], kcs.Kmers[0])
+	if n == 0 {
+		return rc
+	}
+	cnt := mat.Col(nil, 0, &kcs.Counts)[:n]
+	if !kcs.Canonical {
+		// Complement labels then sort them with their counts
+		idx := make([]int, n)
+		for i := range n {
+			rc.Kmers[0][i] = revCompWord(kcs.Kmers[0][i], kcs.K)
+			idx[i] = i
+		}
+		slices.SortFunc(idx, func(a, b int) int {
+			return cmp.Compare(rc.Kmers[0][a], rc.Kmers[0][b])
+		})
+		lab := make([]uint64, n)
+		tmpCnt := make([]float64, n)
+		for i := range n {
+			lab[i] = rc.Kmers[0][idx[i]]
+			tmpCnt[i] = cnt[idx[i]]
+		}
+		rc.Kmers[0] = lab
+		cnt = tmpCnt
+	}
+	rc.Counts = *mat.NewDense(n, 1, nil)
+	rc.Counts.SetCol(0, cnt)
+	return rc
+}
@@ -2,6 +2,8 @@ package kmer
 
 import (
 	"cmp"
+	"errors"
+	"io"
 	"slices"
 
 	"gonum.org/v1/gonum/mat"
@@ -35,7 +37,9 @@ type KCount64 struct {
 	Bwd       uint64
 	Kmers     [][]uint64
 	Counts    mat.Dense
+	SoftMask  bool
 	SkipDeg   int
+	SkipMask  int
 	SkipShort int
 }
 
@@ -47,7 +51,9 @@ func NewKCount64(K int, c bool) *KCount64 {
 	kcs.Canonical = c
 	kcs.Fwd = uint64((64 - K + 1) * 2)
 	kcs.Bwd = uint64((64 - K) * 2)
+	kcs.SoftMask = false
 	kcs.SkipDeg = 0
+	kcs.SkipMask = 0
 	kcs.SkipShort = 0
 	kcs.Counts = *mat.NewDense(1, 1, nil)
 	kcs.Kmers = make([][]uint64, 2)
@@ -94,15 +100,17 @@ func NewKCount64(K int, c bool) *KCount64 {
 func (kcs *KCount64) Count(seq *[]byte) error {
 	// Split the input sequence to keep only countable words
 	seqSpl := NewKSplit(kcs.K)
+	seqSpl.SetSoftMask(kcs.SoftMask)
 	err := seqSpl.SplitSeq(seq)
+
+	// Retrieve the number of skipped bases
+	kcs.SkipDeg += seqSpl.NSkipped
+	kcs.SkipMask += seqSpl.NMasked
+	kcs.SkipShort += seqSpl.NTooShort
 	if err != nil {
 		return err
 	}
 
-	// Retrieve the number of skipped bases
-	kcs.SkipDeg = seqSpl.NSkipped
-	kcs.SkipShort = seqSpl.NTooShort
-
 	// Count the number of kmers from seqSpl
 	nKmers := 0
 	for i := range len(seqSpl.SeqSplit) {
@@ -193,14 +201,34 @@ func (kcs *KCount64) Count(seq *[]byte) error {
 		i += cnt
 	}
 
-	// Copy labels and counts
-	kcs.Kmers[0] = make([]uint64, iKmer)
-	kcs.Kmers[1] = make([]uint64, iKmer)
-	copy(kcs.Kmers[0], tmpLab[0][0:iKmer])
-	copy(kcs.Kmers[1], tmpLab[1][0:iKmer])
-	kcs.Counts = *mat.NewDense(iKmer, 1, nil)
-	kcs.Counts.SetCol(0, tmpCnt[0:iKmer])
+	// Add labels and counts to the previous ones
+	n := len(kcs.Kmers[0])
+	kmers, cnt := addSortedCounts(kcs.Kmers, mat.Col(nil, 0, &kcs.Counts)[:n], [][]uint64{tmpLab[0][0:iKmer], tmpLab[1][0:iKmer]}, tmpCnt[0:iKmer])
+	kcs.Kmers[0] = kmers[0]
+	kcs.Kmers[1] = kmers[1]
+	kcs.Counts = *mat.NewDense(len(cnt), 1, cnt)
+
+	return nil
+}
 
+// Add the counts of another counter (union of labels)
+func (kcs *KCount64) AddCounts(o KCount) error {
+	ko, ok := o.(*KCount64)
+	if !ok || ko.K != kcs.K || ko.Canonical != kcs.Canonical {
+		return ErrIncompatibleCounters
+	}
+	kcs.SkipDeg += ko.SkipDeg
+	kcs.SkipMask += ko.SkipMask
+	kcs.SkipShort += ko.SkipShort
+	no := len(ko.Kmers[0])
+	if no == 0 {
+		return nil
+	}
+	n := len(kcs.Kmers[0])
+	kmers, cnt := addSortedCounts(kcs.Kmers, mat.Col(nil, 0, &kcs.Counts)[:n], ko.Kmers, mat.Col(nil, 0, &ko.Counts)[:no])
+	kcs.Kmers[0] = kmers[0]
+	kcs.Kmers[1] = kmers[1]
+	kcs.Counts = *mat.NewDense(len(cnt), 1, cnt)
 	return nil
 }
 
@@ -280,12 +308,16 @@ func (kcs *KCount64) GetSkippedDegeneratedBases() int {
 	return kcs.SkipDeg
 }
 
+func (kcs *KCount64) GetSkippedMaskedBases() int {
+	return kcs.SkipMask
+}
+
 func (kcs *KCount64) GetSkippedTooShortBases() int {
 	return kcs.SkipShort
 }
 
 func (kcs *KCount64) GetSkippedBases() int {
-	return kcs.SkipDeg + kcs.SkipShort
+	return kcs.SkipDeg + kcs.SkipMask + kcs.SkipShort
 }
 
 func (kcs *KCount64) GetKmers() *[][]uint64 {
@@ -300,6 +332,10 @@ func (kcs *KCount64) GetNKmers() int {
 	return len(kcs.Kmers[0])
 }
 
+func (kcs *KCount64) SetSoftMask(m bool) {
+	kcs.SoftMask = m
+}
+
 func (kcs *KCount64) IsCanonical() bool {
 	return kcs.Canonical
 }
@@ -308,3 +344,84 @@ func (kcs *KCount64) GetKmersToSkip() *[]uint8 {
 	out := make([]uint8, kcs.GetNKmers())
 	return &out
 }
+
+func (kcs *KCount64) WriteBinary(w io.Writer) error {
+	// Counts hold a single dummy value when nothing was counted
+	cnt := mat.Col(nil, 0, &kcs.Counts)[:len(kcs.Kmers[0])]
+	err := writeKCountHeader(w, kcountHeader{kind64, kcs.K, kcs.Canonical, kcs.SkipDeg, kcs.SkipMask, kcs.SkipShort, len(cnt)})
+	if err != nil {
+		return err
+	}
+	return writeSortedCounts(w, kcs.Kmers, cnt)
+}
+
+func (kcs *KCount64) ReadBinary(r io.ByteReader) error {
+	h, err := readKCountHeader(r, kind64, kcs.K)
+	if err != nil {
+		return err
+	}
+	if h.canonical != kcs.Canonical {
+		return errors.New("unexpected canonical mode in binary data")
+	}
+	kcs.SkipDeg = h.skipDeg
+	kcs.SkipMask = h.skipMask
+	kcs.SkipShort = h.skipShort
+
+	kmers, cnt, err := readSortedCounts(r, 2, h.n)
+	if err != nil {
+		return err
+	}
+	kcs.Kmers = kmers
+	if h.n == 0 {
+		kcs.Counts = *mat.NewDense(1, 1, nil)
+	} else {
+		kcs.Counts = *mat.NewDense(h.n, 1, cnt)
+	}
+	return nil
+}
+
+// Create a counter of the reverse complemented sequence(s): canonical
+// counts are unchanged
+func (kcs *KCount64) RevComp() KCount {
+	rc := NewKCount64(kcs.K, kcs.Canonical)
+	rc.SoftMask = kcs.SoftMask
+	rc.SkipDeg = kcs.SkipDeg
+	rc.SkipMask = kcs.SkipMask
+	rc.SkipShort = kcs.SkipShort
+
+	n := len(kcs.Kmers[0])
+	rc.Kmers[0] = make([]uint64, n)
+	rc.Kmers[1] = make([]uint64, n)
+	copy(rc.Kmers[0], kcs.Kmers[0])
+	copy(rc.Kmers[1], kcs.Kmers[1])
+	if n == 0 {
+		return rc
+	}
+	cnt := mat.Col(nil, 0, &kcs.Counts)[:n]
+	if !kcs.Canonical {
+		// The first word holds the SubK first bases and the second word
+		// the 32 last ones
+		lab := make([]KLab64, n)
+		idx := make([]int, n)
+		for i := range n {
+			w1 := kcs.Kmers[0][i]
+			w2 := kcs.Kmers[1][i]
+			lab[i].w1 = revCompWord(w2, kcs.SubK)
+			lab[i].w2 = revCompWord(w1<<(2*(32-kcs.SubK))|w2>>(2*kcs.SubK), 32)
+			idx[i] = i
+		}
+		slices.SortFunc(idx, func(a, b int) int {
+			return cmp.Or(cmp.Compare(lab[a].w1, lab[b].w1), cmp.Compare(lab[a].w2, lab[b].w2))
+		})
+		tmpCnt := make([]float64, n)
+		for i := range n {
+			rc.Kmers[0][i] = lab[idx[i]].w1
+			rc.Kmers[1][i] = lab[idx[i]].w2
+			tmpCnt[i] = cnt[idx[i]]
+		}
+		cnt = tmpCnt
+	}
+	rc.Counts = *mat.NewDense(n, 1, nil)
+	rc.Counts.SetCol(0, cnt)
+	return rc
+}
@@ -0,0 +1,388 @@
+package kmer
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Test that reverse complemented counters match counts of the reverse
+// complemented sequence
+func TestRevComp(t *testing.T) {
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCNNTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC")
+	rev := make([]byte, len(seq))
+	comp := map[byte]byte{'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A', 'N': 'N'}
+	for i := range seq {
+		rev[len(seq)-1-i] = comp[seq[i]]
+	}
+
+	for _, k := range []int{4, 11, 40} {
+		kc, err := NewKCount(k, false)
+		if err != nil {
+			t.Fatalf("Failed to create a counter for K=%d: %s", k, err.Error())
+		}
+		kr, _ := NewKCount(k, false)
+		err = kc.Count(&seq)
+		if err != nil {
+			t.Fatalf("Unexpected error occurred while counting kmers: %s", err.Error())
+		}
+		err = kr.Count(&rev)
+		if err != nil {
+			t.Fatalf("Unexpected error occurred while counting kmers: %s", err.Error())
+		}
+
+		rc := kc.RevComp()
+		if rc.GetNKmers() != kr.GetNKmers() {
+			t.Fatalf("Expected %d reverse complemented kmers for K=%d but found %d.", kr.GetNKmers(), k, rc.GetNKmers())
+		}
+		for w := range *kr.GetKmers() {
+			for i := range (*kr.GetKmers())[w] {
+				if (*rc.GetKmers())[w][i] != (*kr.GetKmers())[w][i] {
+					t.Fatalf("Unexpected reverse complemented kmer label for K=%d.", k)
+				}
+			}
+		}
+		if !mat.Equal(rc.GetCounts(), kr.GetCounts()) {
+			t.Errorf("Unexpected reverse complemented kmer counts for K=%d.", k)
+		}
+	}
+}
+
+// Test that binary counters are read back unchanged and that normalized
+// counts are not written
+func TestBinaryCounts(t *testing.T) {
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCNNTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC")
+	for _, k := range []int{4, 11, 40} {
+		for _, c := range []bool{false, true} {
+			kc, err := NewKCount(k, c)
+			if err != nil {
+				t.Fatalf("Failed to create a counter for K=%d: %s", k, err.Error())
+			}
+			err = kc.Count(&seq)
+			if err != nil {
+				t.Fatalf("Unexpected error occurred while counting kmers: %s", err.Error())
+			}
+			var buf bytes.Buffer
+			err = kc.WriteBinary(&buf)
+			if err != nil {
+				t.Fatalf("Failed to write binary counts for K=%d: %s", k, err.Error())
+			}
+			kr, _ := NewKCount(k, c)
+			err = kr.ReadBinary(&buf)
+			if err != nil {
+				t.Fatalf("Failed to read binary counts for K=%d: %s", k, err.Error())
+			}
+			if kr.GetNKmers() != kc.GetNKmers() || !mat.Equal(kr.GetCounts(), kc.GetCounts()) {
+				t.Errorf("Unexpected counts read back for K=%d (canonical: %t).", k, c)
+			}
+
+			cnt := kc.GetCounts()
+			cnt.Scale(0.5, cnt)
+			err = kc.WriteBinary(&bytes.Buffer{})
+			if err != ErrNormalizedCounts {
+				t.Errorf("Expected %v for K=%d but found %v.", ErrNormalizedCounts, k, err)
+			}
+		}
+	}
+}
+
+// Test that memory-bounded counting gives the same counts as in-memory
+// counting
+func TestExternalCount(t *testing.T) {
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCNNTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGCATCGATCGA")
+	for _, k := range []int{11, 40} {
+		for _, c := range []bool{false, true} {
+			kc, _ := NewKCount(k, c)
+			kc.Count(&seq)
+			dir := t.TempDir()
+			ke, err := NewKCountExternal(k, c, MinExternalMemory, dir)
+			if err != nil {
+				t.Fatalf("Failed to create an external counter: %s", err.Error())
+			}
+			// Force several runs, merged in several passes
+			ke.MaxBuf = 10
+			ke.MaxRuns = 3
+			err = ke.Count(&seq)
+			if err != nil {
+				t.Fatalf("Unexpected error occurred while counting kmers: %s", err.Error())
+			}
+			if len(ke.Runs) < 2 {
+				t.Errorf("Expected several sorted runs but found %d.", len(ke.Runs))
+			}
+			err = ke.Finish()
+			if err != nil {
+				t.Fatalf("Failed to merge sorted runs: %s", err.Error())
+			}
+			for w := range *kc.GetKmers() {
+				if !slices.Equal((*kc.GetKmers())[w], (*ke.GetKmers())[w]) {
+					t.Fatalf("Unexpected kmer labels for K=%d (canonical: %t).", k, c)
+				}
+			}
+			if !mat.Equal(kc.GetCounts(), ke.GetCounts()) {
+				t.Errorf("Unexpected kmer counts for K=%d (canonical: %t).", k, c)
+			}
+			if ke.GetSkippedDegeneratedBases() != kc.GetSkippedDegeneratedBases() {
+				t.Errorf("Unexpected number of skipped bases.")
+			}
+			left, _ := os.ReadDir(dir)
+			if len(left) != 0 {
+				t.Errorf("Expected run files to be removed but found %d files.", len(left))
+			}
+		}
+	}
+	_, err := NewKCountExternal(11, false, 1000, "")
+	if err != ErrMemoryLimit {
+		t.Errorf("Expected an error for a too low memory limit.")
+	}
+}
+
+// Test that counting several sequences accumulates counts, sequentially or
+// with several workers
+func TestCountRecords(t *testing.T) {
+	recs := []string{
+		"ACGCTCGCGCGATCGATCGAGCTATGCGTCNNTTGACCATGCAAGTCGATCGGATCGATTACGG",
+		"CATCGACTAGCATCAGCATTTACGAGCGACTAGCATCGATCGA",
+		"ACG",
+		"TTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGAC",
+	}
+	fasta := ""
+	for i := range recs {
+		fasta += ">s" + string(rune('1'+i)) + "\n" + recs[i] + "\n"
+	}
+	file := t.TempDir() + "/test.fasta"
+	err := os.WriteFile(file, []byte(fasta), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the test file: %s", err.Error())
+	}
+
+	for _, k := range []int{4, 11, 40} {
+		for _, c := range []bool{false, true} {
+			// Records are separated by N so that no kmer spans two records
+			join := []byte(strings.Join(recs, "N"))
+			kc, _ := NewKCount(k, c)
+			kc.Count(&join)
+
+			for _, n := range []int{1, 3} {
+				km := NewKmer(k, c)
+				km.Threads = n
+				err = km.LoadSequences(file, "fasta")
+				if err != nil {
+					t.Fatalf("Failed to count kmers with %d workers: %s", n, err.Error())
+				}
+				ks := km.Counter[0]
+				for w := range *kc.GetKmers() {
+					if !slices.Equal((*kc.GetKmers())[w], (*ks.GetKmers())[w]) {
+						t.Fatalf("Unexpected kmer labels for K=%d with %d workers (canonical: %t).", k, n, c)
+					}
+				}
+				if !mat.Equal(kc.GetCounts(), ks.GetCounts()) {
+					t.Errorf("Unexpected kmer counts for K=%d with %d workers (canonical: %t).", k, n, c)
+				}
+			}
+		}
+	}
+}
+
+// Test the selection of written kmers
+func TestFilterKmers(t *testing.T) {
+	seqs := []string{"AAAAAACGTT", "AAAACCCC", "AAAAGGGG"}
+	km := NewKmer(3, false)
+	for i := range seqs {
+		kc, _ := NewKCount(3, false)
+		s := []byte(seqs[i])
+		kc.Count(&s)
+		km.Counter = append(km.Counter, kc)
+	}
+	km.MergeKmers()
+
+	kl := NewKLabel(3)
+	kByte := make([]byte, 3)
+	selected := func() []string {
+		lab := make([]string, 0)
+		for _, i := range km.Selected {
+			kl.Uint64ToBytesAt(km.Counter[0].GetKmers(), i, &kByte)
+			lab = append(lab, string(kByte))
+		}
+		return lab
+	}
+
+	tests := []struct {
+		f    KFilter
+		want []string
+	}{
+		{KFilter{MinCount: 3}, []string{"AAA"}},
+		{KFilter{MinCount: 6, Total: true}, []string{"AAA"}},
+		{KFilter{MaxCount: 1, DropUnique: true}, []string{"AAC"}},
+		{KFilter{DropCore: true, DropUnique: true}, []string{"AAC"}},
+		{KFilter{TopN: 2}, []string{"AAA", "AAC"}},
+	}
+	for _, tc := range tests {
+		err := km.FilterKmers(tc.f)
+		if err != nil {
+			t.Fatalf("Failed to filter kmers: %s", err.Error())
+		}
+		if !slices.Equal(selected(), tc.want) {
+			t.Errorf("Expected kmers %v with filter %+v but found %v.", tc.want, tc.f, selected())
+		}
+	}
+}
+
+// Test the spectrum of a counter and the genome size estimation
+func TestSpectrum(t *testing.T) {
+	seq := []byte("ACGTACGTAAACCCGGGTTTACGT")
+	kc, _ := NewKCount(4, false)
+	kc.Count(&seq)
+	sp := NewKSpectrum(kc, DefaultSpectrumMax)
+	if sp.Distinct != 17 || sp.Hist[1] != 14 || sp.Hist[2] != 2 || sp.Hist[3] != 1 {
+		t.Errorf("Unexpected kmer spectrum %v.", sp.Hist)
+	}
+
+	// Multiplicities above the maximum share the overflow bin
+	seq = append([]byte("ACGTTGCA"), bytes.Repeat([]byte("A"), 50)...)
+	kc, _ = NewKCount(4, false)
+	kc.Count(&seq)
+	if len(NewKSpectrum(kc, 0).Hist) != 49 {
+		t.Errorf("Expected an unbounded spectrum up to 48.")
+	}
+	sp = NewKSpectrum(kc, 3)
+	if len(sp.Hist) != 5 || sp.Hist[1] != 7 || sp.Hist[4] != 1 || sp.Overflow != 48 {
+		t.Errorf("Expected 48 kmers in the overflow bin but found spectrum %v.", sp.Hist)
+	}
+	if sp.Distinct != 8 || sp.Peak != 1 || sp.Solid != 55 {
+		t.Errorf("Expected overflow kmers to be solid but found %+v.", sp)
+	}
+
+	// Errors, then a coverage peak at 20
+	sp = &KSpectrum{Hist: make([]int, 37)}
+	sp.Hist[1], sp.Hist[2], sp.Hist[3] = 5000, 800, 100
+	for m := 4; m <= 36; m++ {
+		sp.Hist[m] = 1000 - 3*(m-20)*(m-20)
+		sp.Distinct += sp.Hist[m]
+	}
+	sp.estimate()
+	if sp.Valley != 3 || sp.Peak != 20 {
+		t.Errorf("Expected a valley at 3 and a peak at 20 but found %d and %d.", sp.Valley, sp.Peak)
+	}
+	if sp.GenomeSize < float64(sp.Distinct) || sp.GenomeSize > 1.1*float64(sp.Distinct) {
+		t.Errorf("Unexpected genome size %.0f for %d distinct solid kmers.", sp.GenomeSize, sp.Distinct)
+	}
+}
+
+// Test the sparse count formats
+func TestSparseCounts(t *testing.T) {
+	lab := []string{"AAA", "AAC", "ACG"}
+	tab := KCountTable{
+		NRows:   3,
+		Label:   func(i int) []byte { return []byte(lab[i]) },
+		Samples: []string{"s1", "s2", "s3"},
+		Counts:  [][]float64{{2, 0, 1}, nil, {0, 0, 3}},
+	}
+	base := t.TempDir() + "/test"
+	for _, f := range []string{CountsFormatLong, CountsFormatMTX} {
+		err := tab.Write(base, f)
+		if err != nil {
+			t.Fatalf("Failed to write the %s format: %s", f, err.Error())
+		}
+	}
+
+	want := map[string]string{
+		"_Long.tsv": "# no counts: s2\nKmer\tSample\tCount\nAAA\ts1\t2\nACG\ts1\t1\nACG\ts3\t3\n",
+		".mtx":      "%%MatrixMarket matrix coordinate integer general\n% no counts: s2\n3 3 3\n1 1 2\n3 1 1\n3 3 3\n",
+		"_Rows.tsv": "AAA\nAAC\nACG\n",
+		"_Cols.tsv": "s1\ns2\ns3\n",
+	}
+	for ext, w := range want {
+		b, err := os.ReadFile(base + ext)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", ext, err.Error())
+		}
+		if string(b) != w {
+			t.Errorf("Unexpected content of %s:\n%s", ext, string(b))
+		}
+	}
+
+	if CheckCountsFormat("csv") != ErrUnsupportedCountsFormat {
+		t.Errorf("Expected an error for an unknown count format.")
+	}
+}
+
+// Test writing a count database, iterating over it and looking kmers up
+func TestCountDB(t *testing.T) {
+	seqs := []string{
+		"ACGCTCGCGCGATCGATCGAGCTATGCGTCNNTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC",
+		"TTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGCATCGATCGAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+	}
+	for _, k := range []int{4, 11, 40} {
+		for _, c := range []bool{false, true} {
+			km := NewKmer(k, c)
+			for i := range seqs {
+				kc, _ := NewKCount(k, c)
+				s := []byte(seqs[i])
+				kc.Count(&s)
+				km.Counter = append(km.Counter, kc)
+				km.Labels = append(km.Labels, "s"+string(rune('1'+i)))
+			}
+			km.MergeKmers()
+			base := t.TempDir() + "/test"
+			err := km.WriteCountDB(base)
+			if err != nil {
+				t.Fatalf("Failed to write the count database: %s", err.Error())
+			}
+			db, err := OpenKCountDB(base + "_KmerCounts.gkdb")
+			if err != nil {
+				t.Fatalf("Failed to open the count database: %s", err.Error())
+			}
+
+			// Expected counts from the counters
+			want := make(map[string][]uint64)
+			kl := NewKLabel(k)
+			kByte := make([]byte, k)
+			for i := range km.Counter[0].GetNKmers() {
+				cnt := []uint64{uint64(km.Counter[0].GetCounts().At(i, 0)), uint64(km.Counter[1].GetCounts().At(i, 0))}
+				if cnt[0] > 0 || cnt[1] > 0 {
+					kl.Uint64ToBytesAt(km.Counter[0].GetKmers(), i, &kByte)
+					want[string(kByte)] = cnt
+				}
+			}
+
+			n := 0
+			prev := ""
+			err = db.Iterate(func(kmer []byte, counts []uint64) error {
+				if string(kmer) <= prev || !slices.Equal(counts, want[string(kmer)]) {
+					t.Fatalf("Unexpected kmer %s with counts %v for K=%d (canonical: %t).", kmer, counts, k, c)
+				}
+				prev = string(kmer)
+				n++
+				return nil
+			})
+			if err != nil || n != len(want) || db.NKmers != n {
+				t.Fatalf("Expected %d kmers for K=%d but iterated over %d.", len(want), k, n)
+			}
+
+			for kmer, cnt := range want {
+				got, err := db.Lookup([]byte(kmer))
+				if err != nil || !slices.Equal(got, cnt) {
+					t.Fatalf("Unexpected counts %v of %s for K=%d (canonical: %t).", got, kmer, k, c)
+				}
+				if c {
+					got, _ = db.Lookup(km.ByteRevComp([]byte(kmer)))
+					if !slices.Equal(got, cnt) {
+						t.Fatalf("Unexpected counts of the reverse complement of %s.", kmer)
+					}
+				}
+			}
+			missing := []byte(strings.Repeat("C", k))
+			if got, _ := db.Lookup(missing); got != nil {
+				t.Errorf("Expected no counts for a missing kmer but found %v.", got)
+			}
+			if _, err = db.Lookup([]byte("ACN")); err != ErrCountDBKmer {
+				t.Errorf("Expected an error for an invalid kmer.")
+			}
+			db.Close()
+		}
+	}
+}
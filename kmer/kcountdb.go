@@ -0,0 +1,398 @@
+package kmer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+/*
+	Binary database of sorted kmer counts. After a header (magic, version,
+	K, strand setting, sample names, number of kmers, block size and
+	number of blocks), kmers are written in label order: each one as its
+	2-bit packed label (2K bits, big-endian, so that bytes compare as
+	labels) followed by one uvarint count per sample. An index of block
+	offsets (little-endian uint64) and the offset of this index end the
+	file. Lookups binary search the first kmer of each block, then scan a
+	single block.
+*/
+
+const (
+	CountDBMagic   string = "GKDB"
+	CountDBVersion uint64 = 1
+	countDBBlock   int    = 256 // Kmers per indexed block
+)
+
+// Errors related to count databases
+var (
+	ErrCountDBFormat  = errors.New("not a kmer count database")
+	ErrCountDBVersion = errors.New("unsupported kmer count database version")
+	ErrCountDBKmer    = errors.New("queried kmer must have the database kmer length and only A, C, G or T bases")
+)
+
+// Number of bytes of a packed label
+func packedLen(k int) int {
+	return (2*k + 7) / 8
+}
+
+// Pack a kmer encoded on two words (as in counters) into 2K bits
+func packKmer(hi, lo uint64, out []byte) {
+	n := len(out)
+	for j := range n {
+		if j < 8 {
+			out[n-1-j] = byte(lo >> (8 * j))
+		} else {
+			out[n-1-j] = byte(hi >> (8 * (j - 8)))
+		}
+	}
+}
+
+// Convert a packed label into bases (out must hold K bytes)
+func unpackKmer(p []byte, out []byte) {
+	k := len(out)
+	n := len(p)
+	for i := range k {
+		bit := 2 * (k - 1 - i)
+		out[i] = "ACGT"[(p[n-1-bit/8]>>(bit%8))&3]
+	}
+}
+
+// Write the kmer counts of all inputs into a count database (kmers absent
+// from every input and kmers not selected by FilterKmers are not written)
+func (km *Kmer) WriteCountDB(ob string) error {
+	if len(km.Counter) == 0 {
+		return errors.New("no kmer counts to write")
+	}
+	if km.IsStd {
+		return ErrNormalizedCounts
+	}
+	if len(km.Counter) > 1 && km.K > MaxKSmall && !km.Merged {
+		return ErrNotMerged
+	}
+
+	// Kmers to write, in label order
+	nSeq := len(km.Counter)
+	ws := *km.Counter[0].GetKmers()
+	cnt := make([][]float64, nSeq)
+	for j := range nSeq {
+		cnt[j] = mat.Col(nil, 0, km.Counter[j].GetCounts())
+	}
+	var toSkip []uint8
+	if km.Canonical {
+		toSkip = *km.Counter[0].GetKmersToSkip()
+	}
+	rows := slices.Clone(km.Selected)
+	if rows == nil {
+		rows = make([]int, 0)
+		for i := range len(ws[0]) {
+			if toSkip == nil || toSkip[i] == uint8(0) {
+				rows = append(rows, i)
+			}
+		}
+	} else {
+		slices.Sort(rows)
+	}
+	rows = slices.DeleteFunc(rows, func(i int) bool {
+		for j := range nSeq {
+			if cnt[j][i] > 0 {
+				return false
+			}
+		}
+		return true
+	})
+	nBlocks := (len(rows) + countDBBlock - 1) / countDBBlock
+
+	f, err := os.Create(ob + "_KmerCounts.gkdb")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw := bufio.NewWriter(f)
+
+	// Header
+	fw.WriteString(CountDBMagic)
+	canonical := uint64(0)
+	if km.Canonical {
+		canonical = 1
+	}
+	for _, v := range []uint64{CountDBVersion, uint64(km.K), canonical, uint64(nSeq)} {
+		err = WriteUvarint(fw, v)
+		if err != nil {
+			return err
+		}
+	}
+	for j := range nSeq {
+		err = WriteString(fw, km.Labels[j])
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range []uint64{uint64(len(rows)), uint64(countDBBlock), uint64(nBlocks)} {
+		err = WriteUvarint(fw, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Kmers and counts (block offsets are recorded for the index)
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	index := make([]uint64, 0, nBlocks)
+	lab := make([]byte, packedLen(km.K))
+	buf := make([]byte, binary.MaxVarintLen64)
+	for r, i := range rows {
+		if r%countDBBlock == 0 {
+			index = append(index, uint64(offset))
+		}
+		if len(ws) == 2 {
+			packKmer(ws[0][i], ws[1][i], lab)
+		} else {
+			packKmer(0, ws[0][i], lab)
+		}
+		fw.Write(lab)
+		offset += int64(len(lab))
+		for j := range nSeq {
+			n := binary.PutUvarint(buf, uint64(cnt[j][i]))
+			fw.Write(buf[:n])
+			offset += int64(n)
+		}
+	}
+
+	// Block index and its offset
+	for _, v := range append(index, uint64(offset)) {
+		err = binary.Write(fw, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Read-only kmer count database, memory-mapped when the platform allows it
+type KCountDB struct {
+	K         int
+	Canonical bool
+	Samples   []string
+	NKmers    int
+	BlockSize int
+	Index     []uint64 // Offset of each block
+	data      []byte
+	end       int // End of kmer data (start of the index)
+	unmap     func([]byte) error
+}
+
+// Open a count database written by WriteCountDB
+func OpenKCountDB(file string) (*KCountDB, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < int64(len(CountDBMagic))+8 {
+		return nil, ErrCountDBFormat
+	}
+
+	var db KCountDB
+	db.data, db.unmap, err = mapFile(f, int(st.Size()))
+	if err != nil {
+		return nil, err
+	}
+	err = db.readHeader()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &db, nil
+}
+
+// Parse the header and the block index
+func (db *KCountDB) readHeader() error {
+	if string(db.data[:len(CountDBMagic)]) != CountDBMagic {
+		return ErrCountDBFormat
+	}
+	r := bytes.NewReader(db.data[len(CountDBMagic):])
+	v, err := ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if v != CountDBVersion {
+		return ErrCountDBVersion
+	}
+	vals := make([]uint64, 3)
+	for i := range vals {
+		vals[i], err = ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+	}
+	db.K = int(vals[0])
+	db.Canonical = vals[1] == 1
+	if db.K <= 0 || db.K > MaxK128Bits {
+		return ErrCountDBFormat
+	}
+	db.Samples = make([]string, vals[2])
+	for j := range db.Samples {
+		db.Samples[j], err = ReadString(r)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range vals {
+		vals[i], err = ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+	}
+	db.NKmers = int(vals[0])
+	db.BlockSize = int(vals[1])
+	nBlocks := int(vals[2])
+
+	// Index located by the trailing offset
+	n := len(db.data)
+	db.end = int(binary.LittleEndian.Uint64(db.data[n-8:]))
+	if db.end < 0 || db.end+8*nBlocks+8 != n || db.BlockSize <= 0 {
+		return ErrCountDBFormat
+	}
+	db.Index = make([]uint64, nBlocks)
+	for b := range nBlocks {
+		db.Index[b] = binary.LittleEndian.Uint64(db.data[db.end+8*b:])
+		if db.Index[b] >= uint64(db.end) {
+			return ErrCountDBFormat
+		}
+	}
+	return nil
+}
+
+// Release the database
+func (db *KCountDB) Close() error {
+	if db.data == nil {
+		return nil
+	}
+	err := db.unmap(db.data)
+	db.data = nil
+	return err
+}
+
+// Decode the kmer starting at offset o: counts are stored in cnt and the
+// offset of the next kmer is returned
+func (db *KCountDB) decode(o int, cnt []uint64) (int, error) {
+	o += packedLen(db.K)
+	for j := range cnt {
+		if o >= db.end {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v, n := binary.Uvarint(db.data[o:db.end])
+		if n <= 0 {
+			return 0, ErrCountDBFormat
+		}
+		cnt[j] = v
+		o += n
+	}
+	return o, nil
+}
+
+// Packed (canonical if required) label of a kmer given as bases
+func (db *KCountDB) packQuery(kmer []byte) ([]byte, error) {
+	if len(kmer) != db.K {
+		return nil, ErrCountDBKmer
+	}
+	for _, b := range kmer {
+		switch b {
+		case 'A', 'C', 'G', 'T', 'a', 'c', 'g', 't':
+		default:
+			return nil, ErrCountDBKmer
+		}
+	}
+	lab := make([]byte, packedLen(db.K))
+	rollKmers([][]byte{kmer}, db.K, db.Canonical, func(hi, lo uint64) {
+		packKmer(hi, lo, lab)
+	})
+	return lab, nil
+}
+
+// Counts of a kmer in each sample (nil if the kmer is absent). In
+// canonical mode, a kmer and its reverse complement give the same counts.
+func (db *KCountDB) Lookup(kmer []byte) ([]uint64, error) {
+	lab, err := db.packQuery(kmer)
+	if err != nil {
+		return nil, err
+	}
+	nb := len(lab)
+
+	// Last block whose first kmer is not greater than the query
+	b, found := slices.BinarySearchFunc(db.Index, lab, func(o uint64, q []byte) int {
+		return bytes.Compare(db.data[o:int(o)+nb], q)
+	})
+	if !found {
+		if b == 0 {
+			return nil, nil
+		}
+		b--
+	}
+
+	cnt := make([]uint64, len(db.Samples))
+	o := int(db.Index[b])
+	for i := 0; i < db.BlockSize && b*db.BlockSize+i < db.NKmers; i++ {
+		c := bytes.Compare(db.data[o:o+nb], lab)
+		if c > 0 {
+			break
+		}
+		next, err := db.decode(o, cnt)
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			return cnt, nil
+		}
+		o = next
+	}
+	return nil, nil
+}
+
+// Call f on each kmer (as bases) with its counts, in label order. Slices
+// passed to f are reused; iteration stops on the first error returned by f.
+func (db *KCountDB) Iterate(f func(kmer []byte, counts []uint64) error) error {
+	kmer := make([]byte, db.K)
+	cnt := make([]uint64, len(db.Samples))
+	nb := packedLen(db.K)
+	if len(db.Index) == 0 {
+		return nil
+	}
+	o := int(db.Index[0])
+	for range db.NKmers {
+		if o+nb > db.end {
+			return io.ErrUnexpectedEOF
+		}
+		unpackKmer(db.data[o:o+nb], kmer)
+		next, err := db.decode(o, cnt)
+		if err != nil {
+			return err
+		}
+		err = f(kmer, cnt)
+		if err != nil {
+			return err
+		}
+		o = next
+	}
+	return nil
+}
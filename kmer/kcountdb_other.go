@@ -0,0 +1,18 @@
+//go:build !unix
+
+package kmer
+
+import (
+	"io"
+	"os"
+)
+
+// Read a whole file into memory (no memory mapping on this platform)
+func mapFile(f *os.File, size int) ([]byte, func([]byte) error, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func([]byte) error { return nil }, nil
+}
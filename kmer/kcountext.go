@@ -0,0 +1,446 @@
+package kmer
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"errors"
+	"os"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+/*
+	Memory-bounded kmer counting: kmer occurrences are buffered up to a
+	memory limit, then sorted, collapsed and written into temporary run
+	files that are finally merged (k-way merge) into a regular counter.
+	Runs are merged by groups of at most MaxRuns files (bounded number of
+	open files), in several passes if required.
+*/
+
+// Smallest memory limit (bytes) accepted by external counting
+const MinExternalMemory int64 = 1 << 20
+
+// Default number of runs merged at once
+const DefaultMaxRuns int = 64
+
+// Name pattern of temporary run files
+const runFilePattern string = "kmer-run-*.tmp"
+
+// Number of bytes used by a buffered kmer occurrence
+const kmerOccBytes int64 = 16
+
+var ErrMemoryLimit = errors.New("memory limit is too low for external kmer counting (at least 1 MB)")
+
+// Counter with a bounded kmer buffer (the embedded counter receives the
+// merged labels and counts when Finish is called)
+type KCountExternal struct {
+	KCount
+	K         int
+	Canonical bool
+	SoftMask  bool
+	MaxBuf    int
+	MaxRuns   int // Maximal number of runs merged at once
+	TmpDir    string
+	Buf       []KLab64
+	Runs      []string
+	SkipDeg   int
+	SkipMask  int
+	SkipShort int
+}
+
+// Create an external counter for K > MaxKSmall using at most mem bytes of
+// buffer, runs being written into dir (default temporary directory if
+// empty)
+func NewKCountExternal(K int, c bool, mem int64, dir string) (*KCountExternal, error) {
+	if mem < MinExternalMemory {
+		return nil, ErrMemoryLimit
+	}
+	var kce KCountExternal
+	if K <= MaxKSmall {
+		return nil, errors.New("external counting requires K > 8 (small kmers are counted in a fixed size array)")
+	} else if K <= MaxK64Bits {
+		kce.KCount = NewKCount32(K, c)
+	} else if K <= MaxK128Bits {
+		kce.KCount = NewKCount64(K, c)
+	} else {
+		return nil, ErrKTooLarge
+	}
+	kce.K = K
+	kce.Canonical = c
+	kce.SoftMask = false
+	kce.MaxBuf = int(mem / kmerOccBytes)
+	kce.MaxRuns = DefaultMaxRuns
+	kce.TmpDir = dir
+	kce.Runs = make([]string, 0)
+	return &kce, nil
+}
+
+func (kce *KCountExternal) SetSoftMask(m bool) {
+	kce.SoftMask = m
+	kce.KCount.SetSoftMask(m)
+}
+
+func (kce *KCountExternal) GetSkippedDegeneratedBases() int {
+	return kce.SkipDeg
+}
+
+func (kce *KCountExternal) GetSkippedMaskedBases() int {
+	return kce.SkipMask
+}
+
+func (kce *KCountExternal) GetSkippedTooShortBases() int {
+	return kce.SkipShort
+}
+
+// Add the kmers of a sequence (counts accumulate over calls)
+func (kce *KCountExternal) Count(seq *[]byte) error {
+	seqSpl := NewKSplit(kce.K)
+	seqSpl.SetSoftMask(kce.SoftMask)
+	err := seqSpl.SplitSeq(seq)
+
+	// Retrieve the number of skipped bases
+	kce.SkipDeg += seqSpl.NSkipped
+	kce.SkipMask += seqSpl.NMasked
+	kce.SkipShort += seqSpl.NTooShort
+	if err != nil {
+		return err
+	}
+
+	rollKmers(seqSpl.SeqSplit, kce.K, kce.Canonical, func(hi, lo uint64) {
+		if err != nil {
+			return
+		}
+		if kce.Buf == nil {
+			kce.Buf = make([]KLab64, 0, kce.MaxBuf)
+		}
+		kce.Buf = append(kce.Buf, KLab64{hi, lo})
+		if len(kce.Buf) >= kce.MaxBuf {
+			err = kce.spill()
+		}
+	})
+	return err
+}
+
+// Sort and collapse buffered kmers
+func (kce *KCountExternal) collapse() ([][]uint64, []float64) {
+	slices.SortFunc(kce.Buf, func(a, b KLab64) int {
+		return cmp.Or(cmp.Compare(a.w1, b.w1), cmp.Compare(a.w2, b.w2))
+	})
+	kmers := [][]uint64{make([]uint64, 0), make([]uint64, 0)}
+	cnt := make([]float64, 0)
+	for i := 0; i < len(kce.Buf); {
+		j := i + 1
+		for j < len(kce.Buf) && kce.Buf[j] == kce.Buf[i] {
+			j++
+		}
+		kmers[0] = append(kmers[0], kce.Buf[i].w1)
+		kmers[1] = append(kmers[1], kce.Buf[i].w2)
+		cnt = append(cnt, float64(j-i))
+		i = j
+	}
+	kce.Buf = kce.Buf[:0]
+	return kmers, cnt
+}
+
+// Write buffered kmers into a sorted run file
+func (kce *KCountExternal) spill() error {
+	kmers, cnt := kce.collapse()
+	f, err := os.CreateTemp(kce.TmpDir, runFilePattern)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	kce.Runs = append(kce.Runs, f.Name())
+
+	fw := bufio.NewWriter(f)
+	err = WriteUvarint(fw, uint64(len(cnt)))
+	if err != nil {
+		return err
+	}
+	err = writeSortedCounts(fw, kmers, cnt)
+	if err != nil {
+		return err
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Remove temporary run files
+func (kce *KCountExternal) Clean() {
+	for _, r := range kce.Runs {
+		os.Remove(r)
+	}
+	kce.Runs = kce.Runs[:0]
+}
+
+// Sequential reader of a sorted run (same encoding as writeSortedCounts)
+type kmerRun struct {
+	r    *bufio.Reader
+	n    int
+	i    int
+	lab  KLab64
+	cnt  float64
+	prev KLab64
+}
+
+// Read the next kmer of a run (false at the end)
+func (kr *kmerRun) next() (bool, error) {
+	if kr.i == kr.n {
+		return false, nil
+	}
+	d, err := ReadUvarint(kr.r)
+	if err != nil {
+		return false, err
+	}
+	w2, err := ReadUvarint(kr.r)
+	if err != nil {
+		return false, err
+	}
+	if d == 0 && kr.i > 0 {
+		w2 += kr.prev.w2
+	}
+	c, err := ReadUvarint(kr.r)
+	if err != nil {
+		return false, err
+	}
+	kr.lab = KLab64{kr.prev.w1 + d, w2}
+	kr.cnt = float64(c)
+	kr.prev = kr.lab
+	kr.i++
+	return true, nil
+}
+
+// Min-heap of runs ordered by their current kmer
+type runHeap []*kmerRun
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	return cmp.Or(cmp.Compare(h[i].lab.w1, h[j].lab.w1), cmp.Compare(h[i].lab.w2, h[j].lab.w2)) < 0
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*kmerRun)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// K-way merge of runs: f is called on each distinct kmer (sorted) with its
+// total count
+func mergeRuns(runs []string, f func(lab KLab64, c float64) error) error {
+	h := make(runHeap, 0, len(runs))
+	for _, name := range runs {
+		fh, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		kr := &kmerRun{r: bufio.NewReader(fh)}
+		n, err := ReadUvarint(kr.r)
+		if err != nil {
+			return err
+		}
+		kr.n = int(n)
+		ok, err := kr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, kr)
+		}
+	}
+	heap.Init(&h)
+
+	first := true
+	var lab KLab64
+	c := 0.0
+	for len(h) > 0 {
+		kr := h[0]
+		if !first && kr.lab == lab {
+			c += kr.cnt
+		} else {
+			if !first {
+				err := f(lab, c)
+				if err != nil {
+					return err
+				}
+			}
+			first = false
+			lab = kr.lab
+			c = kr.cnt
+		}
+		ok, err := kr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	if !first {
+		return f(lab, c)
+	}
+	return nil
+}
+
+// Merge a group of runs into a new run file (read twice: the number of
+// distinct kmers comes first)
+func (kce *KCountExternal) mergeGroup(group []string) (string, error) {
+	n := 0
+	err := mergeRuns(group, func(lab KLab64, c float64) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(kce.TmpDir, runFilePattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fw := bufio.NewWriter(f)
+	err = WriteUvarint(fw, uint64(n))
+	if err != nil {
+		return f.Name(), err
+	}
+
+	// Same encoding as writeSortedCounts (two words)
+	var prev KLab64
+	first := true
+	err = mergeRuns(group, func(lab KLab64, c float64) error {
+		w2 := lab.w2
+		if lab.w1 == prev.w1 && !first {
+			w2 -= prev.w2
+		}
+		for _, v := range []uint64{lab.w1 - prev.w1, w2, uint64(c)} {
+			err := WriteUvarint(fw, v)
+			if err != nil {
+				return err
+			}
+		}
+		prev = lab
+		first = false
+		return nil
+	})
+	if err != nil {
+		return f.Name(), err
+	}
+	err = fw.Flush()
+	if err != nil {
+		return f.Name(), err
+	}
+	return f.Name(), f.Close()
+}
+
+// Merge runs by groups of MaxRuns until they can be merged at once (merged
+// runs are removed)
+func (kce *KCountExternal) reduceRuns() error {
+	fanIn := max(kce.MaxRuns, 2)
+	for len(kce.Runs) > fanIn {
+		runs := make([]string, 0, (len(kce.Runs)+fanIn-1)/fanIn)
+		for len(kce.Runs) > 0 {
+			group := kce.Runs[:min(fanIn, len(kce.Runs))]
+			name, err := kce.mergeGroup(group)
+			if name != "" {
+				runs = append(runs, name)
+			}
+			if err != nil {
+				// Remaining runs are removed by Clean
+				kce.Runs = append(runs, kce.Runs...)
+				return err
+			}
+			for _, r := range group {
+				os.Remove(r)
+			}
+			kce.Runs = kce.Runs[len(group):]
+		}
+		kce.Runs = runs
+	}
+	return nil
+}
+
+// Merge the runs and the buffer into the embedded counter, then remove the
+// run files. Runs are read twice so that the result is allocated once.
+func (kce *KCountExternal) Finish() error {
+	defer kce.Clean()
+	if len(kce.Buf) > 0 || len(kce.Runs) == 0 {
+		err := kce.spill()
+		if err != nil {
+			return err
+		}
+	}
+	kce.Buf = nil
+	err := kce.reduceRuns()
+	if err != nil {
+		return err
+	}
+
+	// Count distinct kmers
+	n := 0
+	err = mergeRuns(kce.Runs, func(lab KLab64, c float64) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Fill labels and counts
+	nWords := 1
+	if kce.K > MaxK64Bits {
+		nWords = 2
+	}
+	kmers := make([][]uint64, nWords)
+	for w := range nWords {
+		kmers[w] = make([]uint64, n)
+	}
+	cnt := make([]float64, max(n, 1))
+	i := 0
+	err = mergeRuns(kce.Runs, func(lab KLab64, c float64) error {
+		if nWords == 1 {
+			kmers[0][i] = lab.w2
+		} else {
+			kmers[0][i] = lab.w1
+			kmers[1][i] = lab.w2
+		}
+		cnt[i] = c
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return kce.setCounts(kmers, cnt)
+}
+
+// Set merged labels, counts and skipped bases into the embedded counter
+// (counts are used as the matrix data)
+func (kce *KCountExternal) setCounts(kmers [][]uint64, cnt []float64) error {
+	dense := mat.NewDense(len(cnt), 1, cnt)
+	switch kc := kce.KCount.(type) {
+	case *KCount32:
+		kc.Kmers[0] = kmers[0]
+		kc.Counts = *dense
+		kc.SkipDeg, kc.SkipMask, kc.SkipShort = kce.SkipDeg, kce.SkipMask, kce.SkipShort
+	case *KCount64:
+		kc.Kmers[0] = kmers[0]
+		kc.Kmers[1] = kmers[1]
+		kc.Counts = *dense
+		kc.SkipDeg, kc.SkipMask, kc.SkipShort = kce.SkipDeg, kce.SkipMask, kce.SkipShort
+	default:
+		return errors.New("unsupported counter for external counting")
+	}
+	return nil
+}
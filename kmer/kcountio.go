@@ -0,0 +1,207 @@
+package kmer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+/*
+	Binary serialization of kmer counters
+*/
+
+// Counter kinds stored in binary files
+const (
+	kindSmall uint8 = iota
+	kind32
+	kind64
+)
+
+// Common header of a serialized counter
+type kcountHeader struct {
+	kind      uint8
+	k         int
+	canonical bool
+	skipDeg   int
+	skipMask  int
+	skipShort int
+	n         int
+}
+
+// Reader of binary data (e.g. bufio.Reader or bytes.Reader)
+type BinaryReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Write an unsigned varint (byte by byte, without allocation, if w is an
+// io.ByteWriter such as a bufio.Writer)
+func WriteUvarint(w io.Writer, v uint64) error {
+	bw, ok := w.(io.ByteWriter)
+	if !ok {
+		_, err := w.Write(binary.AppendUvarint(nil, v))
+		return err
+	}
+	for v >= 0x80 {
+		err := bw.WriteByte(byte(v) | 0x80)
+		if err != nil {
+			return err
+		}
+		v >>= 7
+	}
+	return bw.WriteByte(byte(v))
+}
+
+// Read an unsigned varint (a truncated value is an unexpected EOF)
+func ReadUvarint(r io.ByteReader) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// Write a string preceded by its length
+func WriteString(w io.Writer, s string) error {
+	err := WriteUvarint(w, uint64(len(s)))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+// Read a string written by WriteString
+func ReadString(r BinaryReader) (string, error) {
+	n, err := ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeKCountHeader(w io.Writer, h kcountHeader) error {
+	vals := []uint64{uint64(h.kind), uint64(h.k), 0, uint64(h.skipDeg), uint64(h.skipMask), uint64(h.skipShort), uint64(h.n)}
+	if h.canonical {
+		vals[2] = 1
+	}
+	for _, v := range vals {
+		err := WriteUvarint(w, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a counter header and check it matches the expected kind and K
+func readKCountHeader(r io.ByteReader, kind uint8, k int) (kcountHeader, error) {
+	var h kcountHeader
+	vals := make([]uint64, 7)
+	for i := range vals {
+		v, err := ReadUvarint(r)
+		if err != nil {
+			return h, err
+		}
+		vals[i] = v
+	}
+	h.kind = uint8(vals[0])
+	h.k = int(vals[1])
+	h.canonical = vals[2] == 1
+	h.skipDeg = int(vals[3])
+	h.skipMask = int(vals[4])
+	h.skipShort = int(vals[5])
+	h.n = int(vals[6])
+
+	if h.kind != kind {
+		return h, errors.New("unexpected kmer counter type in binary data")
+	}
+	if h.k != k {
+		return h, errors.New("unexpected kmer length in binary data")
+	}
+	return h, nil
+}
+
+// Write sorted labels (delta encoded) and counts
+func writeSortedCounts(w io.Writer, kmers [][]uint64, cnt []float64) error {
+	prev := make([]uint64, len(kmers))
+	for i := range cnt {
+		if len(kmers) == 1 {
+			err := WriteUvarint(w, kmers[0][i]-prev[0])
+			if err != nil {
+				return err
+			}
+			prev[0] = kmers[0][i]
+		} else {
+			// The second word is delta encoded only when the first one is unchanged
+			err := WriteUvarint(w, kmers[0][i]-prev[0])
+			if err != nil {
+				return err
+			}
+			w2 := kmers[1][i]
+			if kmers[0][i] == prev[0] && i > 0 {
+				w2 -= prev[1]
+			}
+			err = WriteUvarint(w, w2)
+			if err != nil {
+				return err
+			}
+			prev[0] = kmers[0][i]
+			prev[1] = kmers[1][i]
+		}
+		err := writeCount(w, cnt[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write a raw count (normalized counts would be truncated)
+func writeCount(w io.Writer, c float64) error {
+	if c < 0 || c != math.Trunc(c) {
+		return ErrNormalizedCounts
+	}
+	return WriteUvarint(w, uint64(c))
+}
+
+// Read sorted labels and counts written by writeSortedCounts
+func readSortedCounts(r io.ByteReader, nWords int, n int) ([][]uint64, []float64, error) {
+	kmers := make([][]uint64, nWords)
+	for i := range nWords {
+		kmers[i] = make([]uint64, n)
+	}
+	cnt := make([]float64, n)
+	prev := make([]uint64, nWords)
+	for i := range n {
+		d, err := ReadUvarint(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		kmers[0][i] = prev[0] + d
+		if nWords == 2 {
+			w2, err := ReadUvarint(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if d == 0 && i > 0 {
+				w2 += prev[1]
+			}
+			kmers[1][i] = w2
+			prev[1] = w2
+		}
+		prev[0] = kmers[0][i]
+		c, err := ReadUvarint(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		cnt[i] = float64(c)
+	}
+	return kmers, cnt, nil
+}
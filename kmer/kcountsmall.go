@@ -1,6 +1,8 @@
 package kmer
 
 import (
+	"errors"
+	"io"
 	"math"
 
 	"gonum.org/v1/gonum/mat"
@@ -16,7 +18,9 @@ type KCountSmall struct {
 	Bwd       uint32
 	Kmers     [][]uint64
 	Counts    mat.Dense
+	SoftMask  bool
 	SkipDeg   int
+	SkipMask  int
 	SkipShort int
 }
 
@@ -30,7 +34,9 @@ func NewKCountSmall(K int, c bool) *KCountSmall {
 	kcs.ToSkip = make([]uint8, nKmers)
 	kcs.Fwd = uint32((16 - K + 1) * 2)
 	kcs.Bwd = uint32((16 - K) * 2)
+	kcs.SoftMask = false
 	kcs.SkipDeg = 0
+	kcs.SkipMask = 0
 	kcs.SkipShort = 0
 	kcs.Counts = *mat.NewDense(nKmers, 1, nil)
 	kcs.Kmers = make([][]uint64, 1)
@@ -60,6 +66,13 @@ func NewKCountSmall(K int, c bool) *KCountSmall {
 		kcs.RcConvert['g'] = uint32(1) << n
 		kcs.RcConvert['A'] = uint32(3) << n
 		kcs.RcConvert['a'] = uint32(3) << n
+
+		// Non-canonical kmers are never counted
+		for i := range nKmers {
+			if revCompWord(uint64(i), K) < uint64(i) {
+				kcs.ToSkip[i] = uint8(1)
+			}
+		}
 	}
 
 	return &kcs
@@ -68,17 +81,19 @@ func NewKCountSmall(K int, c bool) *KCountSmall {
 func (kcs *KCountSmall) Count(seq *[]byte) error {
 	// Split the input sequence to keep only countable words
 	seqSpl := NewKSplit(kcs.K)
+	seqSpl.SetSoftMask(kcs.SoftMask)
 	err := seqSpl.SplitSeq(seq)
+
+	// Retrieve the number of skipped bases
+	kcs.SkipDeg += seqSpl.NSkipped
+	kcs.SkipMask += seqSpl.NMasked
+	kcs.SkipShort += seqSpl.NTooShort
 	if err != nil {
 		return err
 	}
 
-	// Retrieve the number of skipped bases
-	kcs.SkipDeg = seqSpl.NSkipped
-	kcs.SkipShort = seqSpl.NTooShort
-
-	// Init. count variable
-	cnt := make([]float64, len(kcs.Kmers[0]))
+	// Start from previous counts
+	cnt := mat.Col(nil, 0, &kcs.Counts)
 
 	// Count words
 	if kcs.Canonical {
@@ -94,10 +109,8 @@ func (kcs *KCountSmall) Count(seq *[]byte) error {
 			// Count the first word
 			if w < wrc {
 				cnt[w]++
-				kcs.ToSkip[wrc] = uint8(1)
 			} else if w > wrc {
 				cnt[wrc]++
-				kcs.ToSkip[w] = uint8(1)
 			} else {
 				cnt[w]++
 			}
@@ -108,10 +121,8 @@ func (kcs *KCountSmall) Count(seq *[]byte) error {
 				wrc = (wrc >> 2) | kcs.RcConvert[seqSpl.SeqSplit[iSeq][i]]
 				if w < wrc {
 					cnt[w]++
-					kcs.ToSkip[wrc] = uint8(1)
 				} else if w > wrc {
 					cnt[wrc]++
-					kcs.ToSkip[w] = uint8(1)
 				} else {
 					cnt[w]++
 				}
@@ -151,12 +162,16 @@ func (kcs *KCountSmall) GetSkippedDegeneratedBases() int {
 	return kcs.SkipDeg
 }
 
+func (kcs *KCountSmall) GetSkippedMaskedBases() int {
+	return kcs.SkipMask
+}
+
 func (kcs *KCountSmall) GetSkippedTooShortBases() int {
 	return kcs.SkipShort
 }
 
 func (kcs *KCountSmall) GetSkippedBases() int {
-	return kcs.SkipDeg + kcs.SkipShort
+	return kcs.SkipDeg + kcs.SkipMask + kcs.SkipShort
 }
 
 func (kcs *KCountSmall) GetKmers() *[][]uint64 {
@@ -171,6 +186,10 @@ func (kcs *KCountSmall) GetNKmers() int {
 	return len(kcs.Kmers[0])
 }
 
+func (kcs *KCountSmall) SetSoftMask(m bool) {
+	kcs.SoftMask = m
+}
+
 func (kcs *KCountSmall) IsCanonical() bool {
 	return kcs.Canonical
 }
@@ -178,3 +197,105 @@ func (kcs *KCountSmall) IsCanonical() bool {
 func (kcs *KCountSmall) GetKmersToSkip() *[]uint8 {
 	return &kcs.ToSkip
 }
+
+func (kcs *KCountSmall) WriteBinary(w io.Writer) error {
+	cnt := mat.Col(nil, 0, &kcs.Counts)
+	err := writeKCountHeader(w, kcountHeader{kindSmall, kcs.K, kcs.Canonical, kcs.SkipDeg, kcs.SkipMask, kcs.SkipShort, len(cnt)})
+	if err != nil {
+		return err
+	}
+
+	// Dense counts (labels are implicit)
+	for i := range cnt {
+		err = writeCount(w, cnt[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Kmers to skip are only relevant in canonical mode
+	if kcs.Canonical {
+		bits := make([]byte, (len(kcs.ToSkip)+7)/8)
+		for i := range kcs.ToSkip {
+			if kcs.ToSkip[i] != 0 {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		_, err = w.Write(bits)
+	}
+	return err
+}
+
+func (kcs *KCountSmall) ReadBinary(r io.ByteReader) error {
+	h, err := readKCountHeader(r, kindSmall, kcs.K)
+	if err != nil {
+		return err
+	}
+	if h.canonical != kcs.Canonical {
+		return errors.New("unexpected canonical mode in binary data")
+	}
+	if h.n != len(kcs.Kmers[0]) {
+		return errors.New("unexpected number of kmers in binary data")
+	}
+	kcs.SkipDeg = h.skipDeg
+	kcs.SkipMask = h.skipMask
+	kcs.SkipShort = h.skipShort
+
+	cnt := make([]float64, h.n)
+	for i := range cnt {
+		c, err := ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		cnt[i] = float64(c)
+	}
+	kcs.Counts.SetCol(0, cnt)
+
+	if kcs.Canonical {
+		for i := 0; i < len(kcs.ToSkip); i += 8 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 8 && i+j < len(kcs.ToSkip); j++ {
+				kcs.ToSkip[i+j] |= (b >> j) & 1
+			}
+		}
+	}
+	return nil
+}
+
+// Create a counter of the reverse complemented sequence(s): canonical
+// counts are unchanged
+func (kcs *KCountSmall) RevComp() KCount {
+	rc := NewKCountSmall(kcs.K, kcs.Canonical)
+	rc.SoftMask = kcs.SoftMask
+	rc.SkipDeg = kcs.SkipDeg
+	rc.SkipMask = kcs.SkipMask
+	rc.SkipShort = kcs.SkipShort
+	if kcs.Canonical {
+		rc.Counts.Copy(&kcs.Counts)
+		copy(rc.ToSkip, kcs.ToSkip)
+		return rc
+	}
+	for i := range len(kcs.Kmers[0]) {
+		rc.Counts.Set(int(revCompWord(uint64(i), kcs.K)), 0, kcs.Counts.At(i, 0))
+	}
+	return rc
+}
+
+// Add the counts of another counter
+func (kcs *KCountSmall) AddCounts(o KCount) error {
+	ko, ok := o.(*KCountSmall)
+	if !ok || ko.K != kcs.K || ko.Canonical != kcs.Canonical {
+		return ErrIncompatibleCounters
+	}
+	kcs.SkipDeg += ko.SkipDeg
+	kcs.SkipMask += ko.SkipMask
+	kcs.SkipShort += ko.SkipShort
+	kcs.Counts.Add(&kcs.Counts, &ko.Counts)
+	for i := range ko.ToSkip {
+		kcs.ToSkip[i] |= ko.ToSkip[i]
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package kmer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Formats of written kmer count tables
+const (
+	CountsFormatTSV  string = "tsv"  // Dense table, one column per sample
+	CountsFormatLong string = "long" // Non-null counts, one (kmer, sample, count) triplet per line
+	CountsFormatMTX  string = "mtx"  // Matrix Market coordinates with row (kmer) and column (sample) label files
+)
+
+// Error returned for an unknown count table format
+var ErrUnsupportedCountsFormat = errors.New("unsupported kmer count format (expected tsv, long or mtx)")
+
+// Check the name of a count table format (empty means tsv)
+func CheckCountsFormat(f string) error {
+	switch f {
+	case "", CountsFormatTSV, CountsFormatLong, CountsFormatMTX:
+		return nil
+	}
+	return ErrUnsupportedCountsFormat
+}
+
+// Kmer count table written in a sparse format: only non-null counts are
+// written. Rows are kmers sharing the same labels in all samples (merged
+// counters).
+type KCountTable struct {
+	NRows   int
+	Label   func(i int) []byte // Label of row i, nil if the row is not written (the slice may be reused)
+	Rows    []int              // Rows to write in this order (all rows if nil)
+	Samples []string
+	Counts  [][]float64 // Counts[j][i]: count of kmer i in sample j (nil if the sample has no counts)
+	Norm    string      // Normalization method (raw counts if empty)
+}
+
+// Call f on each written row with its label
+func (t *KCountTable) eachRow(f func(i int, lab []byte) error) error {
+	n := t.NRows
+	if t.Rows != nil {
+		n = len(t.Rows)
+	}
+	for r := range n {
+		i := r
+		if t.Rows != nil {
+			i = t.Rows[r]
+		}
+		lab := t.Label(i)
+		if lab == nil {
+			continue
+		}
+		err := f(i, lab)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Samples without counts (NA in dense tables), listed in a comment line as
+// they cannot be told from samples with null counts otherwise
+func (t *KCountTable) missingSamples() string {
+	missing := make([]string, 0)
+	for j := range t.Samples {
+		if t.Counts[j] == nil {
+			missing = append(missing, t.Samples[j])
+		}
+	}
+	return strings.Join(missing, ",")
+}
+
+// Format a count value (raw counts are integers)
+func (t *KCountTable) formatCount(v float64) string {
+	if t.Norm == "" {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write the table in the sparse long format
+func (t *KCountTable) WriteLong(file string) error {
+	return writeLines(file, func(w *bufio.Writer) error {
+		if t.Norm != "" {
+			w.WriteString("# normalization: " + t.Norm + "\n")
+		}
+		if m := t.missingSamples(); m != "" {
+			w.WriteString("# no counts: " + m + "\n")
+		}
+		w.WriteString("Kmer\tSample\tCount\n")
+		return t.eachRow(func(i int, lab []byte) error {
+			for j := range t.Counts {
+				if t.Counts[j] == nil || t.Counts[j][i] == 0 {
+					continue
+				}
+				w.Write(lab)
+				w.WriteByte('\t')
+				w.WriteString(t.Samples[j])
+				w.WriteByte('\t')
+				w.WriteString(t.formatCount(t.Counts[j][i]))
+				w.WriteByte('\n')
+			}
+			return nil
+		})
+	})
+}
+
+// Write the table as a Matrix Market coordinate matrix (kmers as rows,
+// samples as columns) into <base>.mtx, with the row labels in
+// <base>_Rows.tsv and the column labels in <base>_Cols.tsv (one label per
+// line, without header)
+func (t *KCountTable) WriteMatrixMarket(base string) error {
+	// Row labels, written rows and number of non-null counts
+	rows := make([]int, 0)
+	nnz := 0
+	err := writeLines(base+"_Rows.tsv", func(w *bufio.Writer) error {
+		return t.eachRow(func(i int, lab []byte) error {
+			rows = append(rows, i)
+			for j := range t.Counts {
+				if t.Counts[j] != nil && t.Counts[j][i] != 0 {
+					nnz++
+				}
+			}
+			w.Write(lab)
+			return w.WriteByte('\n')
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = writeLines(base+"_Cols.tsv", func(w *bufio.Writer) error {
+		for j := range t.Samples {
+			w.WriteString(t.Samples[j] + "\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeLines(base+".mtx", func(w *bufio.Writer) error {
+		field := "integer"
+		if t.Norm != "" {
+			field = "real"
+		}
+		fmt.Fprintf(w, "%%%%MatrixMarket matrix coordinate %s general\n", field)
+		if t.Norm != "" {
+			w.WriteString("% normalization: " + t.Norm + "\n")
+		}
+		if m := t.missingSamples(); m != "" {
+			w.WriteString("% no counts: " + m + "\n")
+		}
+		fmt.Fprintf(w, "%d %d %d\n", len(rows), len(t.Samples), nnz)
+		for r, i := range rows {
+			for j := range t.Counts {
+				if t.Counts[j] != nil && t.Counts[j][i] != 0 {
+					fmt.Fprintf(w, "%d %d %s\n", r+1, j+1, t.formatCount(t.Counts[j][i]))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Write the table in a sparse format (long or mtx) using a base path
+// without extension
+func (t *KCountTable) Write(base, format string) error {
+	switch format {
+	case CountsFormatLong:
+		return t.WriteLong(base + "_Long.tsv")
+	case CountsFormatMTX:
+		return t.WriteMatrixMarket(base)
+	}
+	return ErrUnsupportedCountsFormat
+}
+
+// Create a file and fill it through a buffered writer
+func writeLines(file string, f func(w *bufio.Writer) error) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	err = f(w)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
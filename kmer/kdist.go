@@ -7,3 +7,10 @@ type KDist interface {
 	GetDistance() float64
 	NeedSelfComparison() bool
 }
+
+// Asymmetric distances also provide the distance from the second vector
+// to the first one
+type KDistAsymmetric interface {
+	KDist
+	GetReverseDistance() float64
+}
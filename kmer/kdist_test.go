@@ -0,0 +1,495 @@
+package kmer
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Test abundance distances on known values and on all-zero vectors
+func TestAbundanceDistances(t *testing.T) {
+	a := mat.NewDense(4, 1, []float64{1, 0, 3, 4})
+	b := mat.NewDense(4, 1, []float64{2, 0, 1, 4})
+	z := mat.NewDense(4, 1, nil)
+
+	tests := []struct {
+		name string
+		kd   KDist
+		ab   float64
+		az   float64
+	}{
+		{"BrayCurtis", NewKDistBrayCurtis(), 3.0 / 15.0, 1},
+		{"Manhattan", NewKDistManhattan(), 3, 8},
+		{"Chebyshev", NewKDistChebyshev(), 2, 4},
+		{"Canberra", NewKDistCanberra(), 1.0/3.0 + 0.5, 3},
+	}
+	for _, tc := range tests {
+		pairs := []struct {
+			x, y *mat.Dense
+			exp  float64
+		}{{a, b, tc.ab}, {a, z, tc.az}, {z, z, 0}}
+		for _, p := range pairs {
+			err := tc.kd.Compute(p.x, p.y)
+			if err != nil {
+				t.Fatalf("Unexpected error occurred while computing the %s distance: %s", tc.name, err.Error())
+			}
+			if math.Abs(tc.kd.GetDistance()-p.exp) > 1e-12 {
+				t.Errorf("Expected a %s distance of %f but found %f.", tc.name, p.exp, tc.kd.GetDistance())
+			}
+		}
+	}
+}
+
+// Test information-theoretic distances
+func TestInformationDistances(t *testing.T) {
+	a := mat.NewDense(4, 1, []float64{1, 1, 0, 0})
+	b := mat.NewDense(4, 1, []float64{0, 0, 1, 1})
+	z := mat.NewDense(4, 1, nil)
+
+	// Disjoint supports: maximal divergence
+	js := NewKDistJS(0, true)
+	js.Compute(a, b)
+	if math.Abs(js.GetDistance()-1) > 1e-12 {
+		t.Errorf("Expected a Jensen-Shannon divergence of 1 but found %f.", js.GetDistance())
+	}
+	js.Compute(a, a)
+	if js.GetDistance() != 0 {
+		t.Errorf("Expected a null Jensen-Shannon divergence but found %f.", js.GetDistance())
+	}
+	js.Compute(z, z)
+	if js.GetDistance() != 0 {
+		t.Errorf("Expected a null Jensen-Shannon divergence between null vectors but found %f.", js.GetDistance())
+	}
+	js.Compute(a, z)
+	if js.GetDistance() != 1 {
+		t.Errorf("Expected a Jensen-Shannon divergence of 1 with a null vector but found %f.", js.GetDistance())
+	}
+
+	kl := NewKDistSymKL(DefaultPseudoCount)
+	err := kl.Compute(a, b)
+	if err != nil || math.IsInf(kl.GetDistance(), 0) || kl.GetDistance() <= 0 {
+		t.Errorf("Expected a finite positive symmetric KL divergence but found %f.", kl.GetDistance())
+	}
+	kl.Compute(z, z)
+	if kl.GetDistance() != 0 {
+		t.Errorf("Expected a null symmetric KL divergence between null vectors but found %f.", kl.GetDistance())
+	}
+
+	n := mat.NewDense(4, 1, []float64{-1, 1, 0, 0})
+	if js.Compute(n, a) != ErrNegativeCounts {
+		t.Errorf("Expected an error on negative counts.")
+	}
+
+	// Canonical counts: non-canonical slots get no pseudocount, distances
+	// equal those between canonical kmers only
+	seqA := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCTTGACCATGCAAGTCGATCGGATCGATTACGG")
+	seqB := []byte("CATCGACTAGCATCAGCATTTACGAGCGACTAGCATCGATCGAACGTTTGACCA")
+	for _, k := range []int{3, 5} {
+		ka, kb := NewKCountSmall(k, true), NewKCountSmall(k, true)
+		ka.Count(&seqA)
+		kb.Count(&seqB)
+		var ca, cb []float64
+		for i, s := range ka.ToSkip {
+			if s == 0 {
+				ca = append(ca, ka.Counts.At(i, 0))
+				cb = append(cb, kb.Counts.At(i, 0))
+			}
+		}
+		// No palindrome for odd K
+		if len(ca) != len(ka.ToSkip)/2 {
+			t.Fatalf("Expected %d canonical kmers for K=%d but found %d.", len(ka.ToSkip)/2, k, len(ca))
+		}
+		canA := mat.NewDense(len(ca), 1, ca)
+		canB := mat.NewDense(len(cb), 1, cb)
+		for _, kd := range []KDistSkipping{NewKDistJS(DefaultPseudoCount, false), NewKDistSymKL(DefaultPseudoCount)} {
+			kd.Compute(canA, canB)
+			want := kd.GetDistance()
+			kd.SetKmersToSkip(ka.GetKmersToSkip())
+			err := kd.Compute(&ka.Counts, &kb.Counts)
+			if err != nil || math.Abs(kd.GetDistance()-want) > 1e-12 {
+				t.Errorf("Expected a distance of %f over canonical kmers for K=%d but found %f.", want, k, kd.GetDistance())
+			}
+		}
+	}
+}
+
+// Test the Markov background and the D2 family
+func TestMarkovD2(t *testing.T) {
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC")
+	kc := NewKCountSmall(5, false)
+	kc.Count(&seq)
+	cnt := mat.Col(nil, 0, kc.GetCounts())
+
+	// Expected counts over all possible kmers sum to the number of kmers
+	for m := range 4 {
+		km, err := NewKMarkov(5, m)
+		if err != nil {
+			t.Fatalf("Failed to create a Markov background of order %d: %s", m, err.Error())
+		}
+		km.Estimate(kc.GetKmers(), cnt)
+		exp := km.Expected(kc.GetKmers(), 100)
+		sum := 0.0
+		for i := range exp {
+			sum += exp[i]
+		}
+		if math.Abs(sum-100) > 1e-9 {
+			t.Errorf("Expected counts of order %d sum to %f instead of 100.", m, sum)
+		}
+	}
+	_, err := NewKMarkov(5, 4)
+	if err == nil {
+		t.Errorf("Expected an error for a Markov order of K-1.")
+	}
+
+	for _, v := range []int{VariantD2, VariantD2S, VariantD2Star} {
+		kd, err := NewKDistD2(5, 1, v)
+		if err != nil {
+			t.Fatalf("Failed to create the D2 distance: %s", err.Error())
+		}
+		kd.SetKmers(kc.GetKmers())
+		err = kd.Compute(kc.GetCounts(), kc.GetCounts())
+		if err != nil {
+			t.Fatalf("Unexpected error occurred while computing the D2 distance: %s", err.Error())
+		}
+		if math.Abs(kd.GetDistance()) > 1e-12 {
+			t.Errorf("Expected a null distance between identical vectors (variant %d) but found %f.", v, kd.GetDistance())
+		}
+
+		// Counts changed in place (e.g. normalized) give the distance of
+		// new counts
+		a := mat.DenseCopyOf(kc.GetCounts())
+		b := mat.DenseCopyOf(kc.GetCounts())
+		kd.Compute(a, b)
+		for i := range 100 {
+			b.Set(i*7%1024, 0, b.At(i*7%1024, 0)+float64(i%5))
+		}
+		kd.Compute(a, b)
+		kn, _ := NewKDistD2(5, 1, v)
+		kn.SetKmers(kc.GetKmers())
+		kn.Compute(a, b)
+		if kd.GetDistance() != kn.GetDistance() {
+			t.Errorf("Expected distance %f after changing counts (variant %d) but found %f.", kn.GetDistance(), v, kd.GetDistance())
+		}
+	}
+}
+
+// Test Jaccard-based distances
+func TestJaccardDistances(t *testing.T) {
+	a := mat.NewDense(5, 1, []float64{1, 2, 0, 4, 0})
+	b := mat.NewDense(5, 1, []float64{2, 0, 0, 4, 3})
+	z := mat.NewDense(5, 1, nil)
+
+	jd := NewKDistJaccard()
+	jd.Compute(a, b)
+	if jd.GetDistance() != 1-2.0/4.0 {
+		t.Errorf("Expected a Jaccard distance of 0.5 but found %f.", jd.GetDistance())
+	}
+	jd.Compute(z, z)
+	if jd.GetDistance() != 0 {
+		t.Errorf("Expected a null Jaccard distance between null vectors but found %f.", jd.GetDistance())
+	}
+
+	wd := NewKDistWeightedJaccard()
+	wd.Compute(a, b)
+	if math.Abs(wd.GetDistance()-(1-5.0/11.0)) > 1e-12 {
+		t.Errorf("Expected a weighted Jaccard distance of %f but found %f.", 1-5.0/11.0, wd.GetDistance())
+	}
+
+	cd := NewKDistContainment()
+	s := mat.NewDense(5, 1, []float64{1, 0, 0, 4, 0})
+	cd.Compute(s, a)
+	if cd.GetDistance() != 0 || math.Abs(cd.GetReverseDistance()-1.0/3.0) > 1e-12 {
+		t.Errorf("Expected containment distances of 0 and %f but found %f and %f.", 1.0/3.0, cd.GetDistance(), cd.GetReverseDistance())
+	}
+	cd.Compute(z, a)
+	if cd.GetDistance() != 0 || cd.GetReverseDistance() != 1 {
+		t.Errorf("Expected containment distances of 0 and 1 with a null vector but found %f and %f.", cd.GetDistance(), cd.GetReverseDistance())
+	}
+}
+
+// Test correlation distances
+func TestCorrelationDistances(t *testing.T) {
+	a := mat.NewDense(4, 1, []float64{1, 2, 3, 4})
+	b := mat.NewDense(4, 1, []float64{1, 4, 9, 16})
+	c := mat.NewDense(4, 1, []float64{4, 3, 2, 1})
+	z := mat.NewDense(4, 1, nil)
+
+	pd := NewKDistPearson()
+	pd.Compute(a, c)
+	if math.Abs(pd.GetDistance()-2) > 1e-12 {
+		t.Errorf("Expected a Pearson distance of 2 but found %f.", pd.GetDistance())
+	}
+	pd.Compute(z, z)
+	if pd.GetDistance() != 0 {
+		t.Errorf("Expected a null Pearson distance between null vectors but found %f.", pd.GetDistance())
+	}
+	sd := NewKDistSpearman()
+	sd.Compute(a, b)
+	if math.Abs(sd.GetDistance()) > 1e-12 {
+		t.Errorf("Expected a null Spearman distance between monotonic vectors but found %f.", sd.GetDistance())
+	}
+	r := ranks([]float64{3, 1, 3, 0})
+	if r[0] != 3.5 || r[1] != 2 || r[2] != 3.5 || r[3] != 1 {
+		t.Errorf("Unexpected ranks %v.", r)
+	}
+
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC")
+	kc := NewKCountSmall(4, false)
+	kc.Count(&seq)
+	td, err := NewKDistTetra(4)
+	if err != nil {
+		t.Fatalf("Failed to create the TETRA distance: %s", err.Error())
+	}
+	td.SetKmers(kc.GetKmers())
+	err = td.Compute(kc.GetCounts(), kc.GetCounts())
+	if err != nil || math.Abs(td.GetDistance()) > 1e-12 {
+		t.Errorf("Expected a null TETRA distance between identical vectors but found %f.", td.GetDistance())
+	}
+
+	// Counts changed in place give the distance of new counts
+	a = mat.DenseCopyOf(kc.GetCounts())
+	b = mat.DenseCopyOf(kc.GetCounts())
+	td.Compute(a, b)
+	for i := range 50 {
+		b.Set(i*5%256, 0, b.At(i*5%256, 0)+float64(i%3))
+	}
+	td.Compute(a, b)
+	tn, _ := NewKDistTetra(4)
+	tn.SetKmers(kc.GetKmers())
+	tn.Compute(a, b)
+	if td.GetDistance() != tn.GetDistance() {
+		t.Errorf("Expected TETRA distance %f after changing counts but found %f.", tn.GetDistance(), td.GetDistance())
+	}
+
+	// Canonical counts are rejected
+	p := NewKDistParams(4)
+	p.Canonical = true
+	_, err = NewKDistByName("TETRA", p)
+	var pe *KDistParamError
+	if !errors.As(err, &pe) || pe.Param != "Canonical" {
+		t.Errorf("Expected an invalid Canonical parameter for TETRA but found %v.", err)
+	}
+}
+
+// Test sketch distances and sketch files
+func TestSketch(t *testing.T) {
+	seq := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCTTGACCATGCAAGTCGATCGGATCGATTACGGCATCGACTAGCATCAGCATTTACGAGCGACTAGC")
+	rev := make([]byte, len(seq))
+	comp := map[byte]byte{'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A'}
+	for i := range seq {
+		rev[len(seq)-1-i] = comp[seq[i]]
+	}
+
+	for _, k := range []int{11, 40} {
+		a, err := NewKSketch(k, 20, true)
+		if err != nil {
+			t.Fatalf("Failed to create a sketch for K=%d: %s", k, err.Error())
+		}
+		b, _ := NewKSketch(k, 20, true)
+		a.Sketch(&seq)
+		b.Sketch(&rev)
+		ksd := NewKSketchDist()
+		err = ksd.Compute(a, b)
+		if err != nil || ksd.GetDistance() != 0 || ksd.Shared != 20 {
+			t.Errorf("Expected identical canonical sketches for K=%d but found a distance of %f.", k, ksd.GetDistance())
+		}
+	}
+
+	a, _ := NewKSketch(11, 20, false)
+	a.Name = "a"
+	a.Sketch(&seq)
+	b, _ := NewKSketch(11, 20, false)
+	b.Sketch(&rev)
+	ksd := NewKSketchDist()
+	ksd.Compute(a, b)
+	if ksd.GetDistance() <= 0 || ksd.GetPValue() > 1 {
+		t.Errorf("Unexpected distance %f between a sequence and its reverse complement.", ksd.GetDistance())
+	}
+
+	file := t.TempDir() + "/test.gsks"
+	err := WriteSketchFile(file, []*KSketch{a, b})
+	if err != nil {
+		t.Fatalf("Failed to write sketches: %s", err.Error())
+	}
+	sk, err := ReadSketchFile(file)
+	if err != nil || len(sk) != 2 {
+		t.Fatalf("Failed to read sketches back.")
+	}
+	if sk[0].Name != "a" || sk[0].NKmers != a.NKmers || !slices.Equal(sk[0].GetHashes(), a.GetHashes()) {
+		t.Errorf("Sketches read back differ from the written ones.")
+	}
+
+	c, _ := NewKSketch(12, 20, false)
+	if ksd.Compute(a, c) != ErrIncompatibleSketches {
+		t.Errorf("Expected an error when comparing sketches with different kmer lengths.")
+	}
+
+	// Repeated kmers do not change the p-value (8 distinct kmers)
+	unit := []byte("ACGTTGCA")
+	mixed := append(slices.Clone(seq), bytes.Repeat(unit, 3)...)
+	m, _ := NewKSketch(11, 20, false)
+	m.Sketch(&mixed)
+	var pv []float64
+	for _, n := range []int{5, 500} {
+		r := bytes.Repeat(unit, n)
+		rs, _ := NewKSketch(11, 20, false)
+		rs.Sketch(&r)
+		if rs.Cardinality() != 8 {
+			t.Errorf("Expected 8 distinct kmers in the repeat but found %f.", rs.Cardinality())
+		}
+		ksd.Compute(rs, m)
+		if ksd.Shared == 0 {
+			t.Fatalf("Expected shared values between the repeat and the mixed sequence.")
+		}
+		pv = append(pv, ksd.GetPValue())
+	}
+	if pv[0] != pv[1] {
+		t.Errorf("Expected the same p-value whatever the number of repeats but found %g and %g.", pv[0], pv[1])
+	}
+
+	// Number of distinct kmers estimated from a full sketch
+	rnd := rand.New(rand.NewPCG(1, 2))
+	long := make([]byte, 100000)
+	for i := range long {
+		long[i] = "ACGT"[rnd.IntN(4)]
+	}
+	ls, _ := NewKSketch(21, 1000, false)
+	ls.Sketch(&long)
+	if n := ls.Cardinality(); math.Abs(n-float64(ls.NKmers)) > 0.1*float64(ls.NKmers) {
+		t.Errorf("Expected about %d distinct kmers but found %f.", ls.NKmers, n)
+	}
+}
+
+// Test the registry of distance methods
+func TestKDistRegistry(t *testing.T) {
+	p := NewKDistParams(4)
+	for _, name := range []string{"euclidean", "L1", "D2*", "jsd", "TeTrA"} {
+		_, err := NewKDistByName(name, p)
+		if err != nil {
+			t.Errorf("Failed to create the %s distance: %s", name, err.Error())
+		}
+	}
+	m, err := LookupKDist("ruzicka")
+	if err != nil || m.Name != "WeightedJaccard" {
+		t.Errorf("Failed to find a method from its alias.")
+	}
+	_, err = NewKDistByName("unknown", p)
+	if !errors.Is(err, ErrUnsupportedDistance) {
+		t.Errorf("Expected an error for an unknown distance method.")
+	}
+	p.PseudoCount = 0
+	_, err = NewKDistByName("SymKL", p)
+	var pe *KDistParamError
+	if !errors.As(err, &pe) || pe.Param != "PseudoCount" {
+		t.Errorf("Expected a parameter error for a null Kullback-Leibler pseudocount.")
+	}
+	err = RegisterKDist(KDistMethod{Name: "cosine", New: func(p KDistParams) (KDist, error) { return NewKDistCosine(), nil }})
+	if !errors.Is(err, ErrDuplicateDistance) {
+		t.Errorf("Expected an error when registering a method twice.")
+	}
+}
+
+// Test that sparse profiles give the same distances as merged counts
+func TestSparseProfiles(t *testing.T) {
+	seqA := []byte("ACGCTCGCGCGATCGATCGAGCTATGCGTCTTGACCATGCAAGTCGATCGGATCGATTACGG")
+	seqB := []byte("CATCGACTAGCATCAGCATTTACGAGCGACTAGCGATCGATCGAGCTATGCGTCTTGACCAT")
+	for _, k := range []int{11, 40} {
+		a, _ := NewKCount(k, false)
+		b, _ := NewKCount(k, false)
+		a.Count(&seqA)
+		b.Count(&seqB)
+
+		// Distances from sparse profiles (before merging)
+		names := []string{"Euclidean", "Cosine", "Mash", "BrayCurtis", "Manhattan", "Chebyshev", "Canberra", "Jaccard", "WeightedJaccard", "Containment"}
+		sparse := make([]float64, len(names))
+		for i, name := range names {
+			kd, _ := NewKDistByName(name, NewKDistParams(k))
+			err := kd.(KDistSparse).ComputeProfiles(NewKProfile(a), NewKProfile(b))
+			if err != nil {
+				t.Fatalf("Failed to compute the %s distance from sparse profiles: %s", name, err.Error())
+			}
+			sparse[i] = kd.GetDistance()
+		}
+
+		// Distances from merged counts
+		a.MergeKmers(b.GetKmers())
+		b.MergeKmers(a.GetKmers())
+		for i, name := range names {
+			kd, _ := NewKDistByName(name, NewKDistParams(k))
+			kd.Compute(a.GetCounts(), b.GetCounts())
+			if math.Abs(kd.GetDistance()-sparse[i]) > 1e-12 {
+				t.Errorf("Sparse %s distance %f differs from %f for K=%d.", name, sparse[i], kd.GetDistance(), k)
+			}
+		}
+	}
+}
+
+// Test that all-pairs distances match pairwise ones
+func TestAllPairs(t *testing.T) {
+	v := []*mat.Dense{
+		mat.NewDense(4, 1, []float64{1, 0, 3, 4}),
+		mat.NewDense(4, 1, []float64{2, 1, 0, 4}),
+		mat.NewDense(4, 1, []float64{1, 0, 3, 4}),
+	}
+	m, err := StackCounts(v)
+	if err != nil {
+		t.Fatalf("Failed to stack count vectors: %s", err.Error())
+	}
+	for _, kd := range []KDistAllPairs{NewKDistEuclidean(), NewKDistCosine()} {
+		d := kd.ComputeAll(m)
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				kd.Compute(v[i], v[j])
+				if math.Abs(d.At(i, j)-kd.GetDistance()) > 1e-9 {
+					t.Errorf("All-pairs distance %f differs from %f.", d.At(i, j), kd.GetDistance())
+				}
+			}
+		}
+		if math.Abs(d.At(0, 2)) > 1e-12 {
+			t.Errorf("Expected a null distance between identical vectors but found %f.", d.At(0, 2))
+		}
+	}
+
+	// Raw counts of random sequences (K=6), the last one close to the first
+	rnd := rand.New(rand.NewPCG(3, 4))
+	var cnt []*mat.Dense
+	var base []byte
+	for i := range 5 {
+		seq := make([]byte, 5000)
+		for j := range seq {
+			seq[j] = "ACGT"[rnd.IntN(4)]
+		}
+		if i == 0 {
+			base = seq
+		}
+		if i == 4 {
+			seq = slices.Clone(base)
+			seq[2500] = 'N'
+		}
+		kc := NewKCountSmall(6, false)
+		kc.Count(&seq)
+		cnt = append(cnt, kc.GetCounts())
+	}
+	m, _ = StackCounts(cnt)
+	for _, kd := range []KDistAllPairs{NewKDistEuclidean(), NewKDistCosine()} {
+		d := kd.ComputeAll(m)
+		for i := range cnt {
+			for j := i + 1; j < len(cnt); j++ {
+				kd.Compute(cnt[i], cnt[j])
+				if math.Abs(d.At(i, j)-kd.GetDistance()) > 1e-6*max(1, kd.GetDistance()) {
+					t.Errorf("All-pairs distance %f between raw counts %d and %d differs from %f.", d.At(i, j), i, j, kd.GetDistance())
+				}
+			}
+		}
+	}
+
+	_, err = StackCounts([]*mat.Dense{v[0], mat.NewDense(3, 1, nil)})
+	if err == nil {
+		t.Errorf("Expected an error when stacking vectors of different lengths.")
+	}
+}
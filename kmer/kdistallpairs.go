@@ -0,0 +1,74 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+/*
+	All-pairs distances from a single Gram matrix product
+*/
+
+// Distance computed for all pairs of columns of a kmers x samples matrix
+type KDistAllPairs interface {
+	KDist
+	ComputeAll(m *mat.Dense) *mat.SymDense
+}
+
+// Stack count vectors (sharing the same merged labels) into a kmers x
+// samples matrix
+func StackCounts(v []*mat.Dense) (*mat.Dense, error) {
+	if len(v) == 0 {
+		return nil, errors.New("no kmer counts to stack")
+	}
+	n, _ := v[0].Dims()
+	m := mat.NewDense(n, len(v), nil)
+	for j := range v {
+		r, _ := v[j].Dims()
+		if r != n {
+			return nil, errors.New("cannot compare vectors of kmer counts with different lengths")
+		}
+		m.Slice(0, n, j, j+1).(*mat.Dense).Copy(v[j])
+	}
+	return m, nil
+}
+
+// Dot products between the columns of a matrix (BLAS rank-k update)
+func GramMatrix(m *mat.Dense) *mat.SymDense {
+	var g mat.SymDense
+	g.SymOuterK(1, m.T())
+	return &g
+}
+
+// All-pairs Euclidean distances: |a-b|^2 = a.a + b.b - 2 a.b (values lost
+// in rounding errors are set to zero)
+func (kde *KDistEuclidean) ComputeAll(m *mat.Dense) *mat.SymDense {
+	g := GramMatrix(m)
+	n := g.SymmetricDim()
+	d := mat.NewSymDense(n, nil)
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			sq := g.At(i, i) + g.At(j, j) - 2*g.At(i, j)
+			if sq <= 1e-12*(g.At(i, i)+g.At(j, j)) {
+				sq = 0
+			}
+			d.SetSym(i, j, math.Sqrt(sq))
+		}
+	}
+	return d
+}
+
+// All-pairs Cosine distances: 1 - a.b / (|a| |b|)
+func (kde *KDistCosine) ComputeAll(m *mat.Dense) *mat.SymDense {
+	g := GramMatrix(m)
+	n := g.SymmetricDim()
+	d := mat.NewSymDense(n, nil)
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			d.SetSym(i, j, 1-(g.At(i, j)/(math.Sqrt(g.At(i, i))*math.Sqrt(g.At(j, j)))))
+		}
+	}
+	return d
+}
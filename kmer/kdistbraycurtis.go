@@ -0,0 +1,78 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Bray-Curtis dissimilarity: sum|a-b| / sum(|a|+|b|) (0 if both vectors
+// are null)
+type KDistBrayCurtis struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "BrayCurtis",
+		Aliases:     []string{"Bray"},
+		Description: "Bray-Curtis dissimilarity of abundances",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistBrayCurtis(), nil },
+	})
+}
+
+func NewKDistBrayCurtis() *KDistBrayCurtis {
+	var kdb KDistBrayCurtis
+	kdb.Dist = float64(0.0)
+	return &kdb
+}
+
+func (kdb *KDistBrayCurtis) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	num := 0.0
+	den := 0.0
+	for i := range aLen {
+		x := a.At(i, 0)
+		y := b.At(i, 0)
+		num += math.Abs(x - y)
+		den += math.Abs(x) + math.Abs(y)
+	}
+	kdb.Dist = 0.0
+	if den > 0 {
+		kdb.Dist = num / den
+	}
+
+	return nil
+}
+
+func (kdb *KDistBrayCurtis) ComputeProfiles(a *KProfile, b *KProfile) error {
+	num := 0.0
+	den := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		num += math.Abs(x - y)
+		den += math.Abs(x) + math.Abs(y)
+	})
+	if err != nil {
+		return err
+	}
+	kdb.Dist = 0.0
+	if den > 0 {
+		kdb.Dist = num / den
+	}
+
+	return nil
+}
+
+func (kdb *KDistBrayCurtis) GetDistance() float64 {
+	return kdb.Dist
+}
+
+func (kdb *KDistBrayCurtis) NeedSelfComparison() bool {
+	return false
+}
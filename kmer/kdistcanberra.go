@@ -0,0 +1,73 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Canberra distance: sum |a-b|/(|a|+|b|), kmers absent from both
+// vectors are ignored (0 if both vectors are null)
+type KDistCanberra struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Canberra",
+		Description: "Canberra distance (kmers absent from both vectors are ignored)",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistCanberra(), nil },
+	})
+}
+
+func NewKDistCanberra() *KDistCanberra {
+	var kdc KDistCanberra
+	kdc.Dist = float64(0.0)
+	return &kdc
+}
+
+func (kdc *KDistCanberra) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	sum := 0.0
+	for i := range aLen {
+		x := a.At(i, 0)
+		y := b.At(i, 0)
+		den := math.Abs(x) + math.Abs(y)
+		if den > 0 {
+			sum += math.Abs(x-y) / den
+		}
+	}
+	kdc.Dist = sum
+
+	return nil
+}
+
+func (kdc *KDistCanberra) ComputeProfiles(a *KProfile, b *KProfile) error {
+	sum := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		den := math.Abs(x) + math.Abs(y)
+		if den > 0 {
+			sum += math.Abs(x-y) / den
+		}
+	})
+	if err != nil {
+		return err
+	}
+	kdc.Dist = sum
+
+	return nil
+}
+
+func (kdc *KDistCanberra) GetDistance() float64 {
+	return kdc.Dist
+}
+
+func (kdc *KDistCanberra) NeedSelfComparison() bool {
+	return false
+}
@@ -0,0 +1,65 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Chebyshev (L-infinity) distance: max|a-b|
+type KDistChebyshev struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Chebyshev",
+		Aliases:     []string{"LInf"},
+		Description: "Largest absolute count difference",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistChebyshev(), nil },
+	})
+}
+
+func NewKDistChebyshev() *KDistChebyshev {
+	var kdc KDistChebyshev
+	kdc.Dist = float64(0.0)
+	return &kdc
+}
+
+func (kdc *KDistChebyshev) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	dmax := 0.0
+	for i := range aLen {
+		dmax = max(dmax, math.Abs(a.At(i, 0)-b.At(i, 0)))
+	}
+	kdc.Dist = dmax
+
+	return nil
+}
+
+func (kdc *KDistChebyshev) ComputeProfiles(a *KProfile, b *KProfile) error {
+	dmax := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		dmax = max(dmax, math.Abs(x-y))
+	})
+	if err != nil {
+		return err
+	}
+	kdc.Dist = dmax
+
+	return nil
+}
+
+func (kdc *KDistChebyshev) GetDistance() float64 {
+	return kdc.Dist
+}
+
+func (kdc *KDistChebyshev) NeedSelfComparison() bool {
+	return false
+}
@@ -0,0 +1,107 @@
+package kmer
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Containment distance on kmer presence: 1 - |A inter B| / |A| (the
+// reverse distance is 1 - |A inter B| / |B|). A null vector is contained
+// in any vector.
+type KDistContainment struct {
+	Dist    float64
+	RevDist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Containment",
+		Description: "1 - fraction of the kmers of a vector found in the other one (asymmetric)",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistContainment(), nil },
+	})
+}
+
+func NewKDistContainment() *KDistContainment {
+	var kdc KDistContainment
+	kdc.Dist = float64(0.0)
+	kdc.RevDist = float64(0.0)
+	return &kdc
+}
+
+func (kdc *KDistContainment) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	inter := 0.0
+	nA := 0.0
+	nB := 0.0
+	for i := range aLen {
+		x := a.At(i, 0) != 0
+		y := b.At(i, 0) != 0
+		if x {
+			nA++
+		}
+		if y {
+			nB++
+		}
+		if x && y {
+			inter++
+		}
+	}
+	kdc.Dist = 0.0
+	if nA > 0 {
+		kdc.Dist = 1 - inter/nA
+	}
+	kdc.RevDist = 0.0
+	if nB > 0 {
+		kdc.RevDist = 1 - inter/nB
+	}
+
+	return nil
+}
+
+func (kdc *KDistContainment) ComputeProfiles(a *KProfile, b *KProfile) error {
+	inter := 0.0
+	nA := 0.0
+	nB := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		if x != 0 {
+			nA++
+		}
+		if y != 0 {
+			nB++
+		}
+		if x != 0 && y != 0 {
+			inter++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	kdc.Dist = 0.0
+	if nA > 0 {
+		kdc.Dist = 1 - inter/nA
+	}
+	kdc.RevDist = 0.0
+	if nB > 0 {
+		kdc.RevDist = 1 - inter/nB
+	}
+
+	return nil
+}
+
+func (kdc *KDistContainment) GetDistance() float64 {
+	return kdc.Dist
+}
+
+func (kdc *KDistContainment) GetReverseDistance() float64 {
+	return kdc.RevDist
+}
+
+func (kdc *KDistContainment) NeedSelfComparison() bool {
+	return false
+}
@@ -11,6 +11,14 @@ type KDistCosine struct {
 	Dist float64
 }
 
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Cosine",
+		Description: "1 - cosine similarity of count vectors",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistCosine(), nil },
+	})
+}
+
 func NewKDistCosine() *KDistCosine {
 	var kde KDistCosine
 	kde.Dist = float64(0.0)
@@ -42,6 +50,23 @@ func (kde *KDistCosine) Compute(a *mat.Dense, b *mat.Dense) error {
 	return (nil)
 }
 
+func (kde *KDistCosine) ComputeProfiles(a *KProfile, b *KProfile) error {
+	sumXY := 0.0
+	sumX := 0.0
+	sumY := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		sumXY += x * y
+		sumX += x * x
+		sumY += y * y
+	})
+	if err != nil {
+		return err
+	}
+	kde.Dist = 1 - (sumXY / (math.Sqrt(sumX) * math.Sqrt(sumY)))
+
+	return nil
+}
+
 func (kde *KDistCosine) GetDistance() float64 {
 	return kde.Dist
 }
@@ -0,0 +1,175 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// D2 statistic variants
+const (
+	VariantD2     int = iota // Raw counts
+	VariantD2S               // Centered counts, self-standardized
+	VariantD2Star            // Centered counts, standardized by expected counts
+)
+
+// Kmer labels are required by distances relying on a background model.
+// They must be set before calling Compute.
+type KDistLabeled interface {
+	KDist
+	SetKmers(*[][]uint64)
+}
+
+// Alignment-free D2, D2S and D2* dissimilarities, (1 - normalized
+// statistic) / 2 in [0,1]. D2S and D2* center counts on expected counts
+// under an order-m Markov background estimated from each vector. Two null
+// vectors are at distance 0, a null vector is at distance 0.5 from any
+// other one.
+type KDistD2 struct {
+	Dist    float64
+	K       int
+	Order   int
+	Variant int
+	Kmers   *[][]uint64
+}
+
+func init() {
+	variants := []struct {
+		name    string
+		aliases []string
+		desc    string
+		v       int
+	}{
+		{"D2", nil, "D2 dissimilarity (raw counts)", VariantD2},
+		{"D2S", nil, "D2S dissimilarity (counts centered on a Markov background)", VariantD2S},
+		{"D2Star", []string{"D2*"}, "D2* dissimilarity (centered counts standardized by expected counts)", VariantD2Star},
+	}
+	for _, vt := range variants {
+		mustRegisterKDist(KDistMethod{
+			Name:        vt.name,
+			Aliases:     vt.aliases,
+			Description: vt.desc,
+			Params:      []string{"K", "MarkovOrder"},
+			New: func(p KDistParams) (KDist, error) {
+				kd, err := NewKDistD2(p.K, p.MarkovOrder, vt.v)
+				if err != nil {
+					return nil, &KDistParamError{"MarkovOrder", err.Error()}
+				}
+				return kd, nil
+			},
+		})
+	}
+}
+
+func NewKDistD2(k int, m int, v int) (*KDistD2, error) {
+	var kdd KDistD2
+	kdd.Dist = float64(0.0)
+	kdd.K = k
+	kdd.Order = m
+	kdd.Variant = v
+	if v != VariantD2 {
+		// Check the Markov order
+		_, err := NewKMarkov(k, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &kdd, nil
+}
+
+func (kdd *KDistD2) SetKmers(kmers *[][]uint64) {
+	kdd.Kmers = kmers
+}
+
+// Expected counts of a vector (computed at each call, as counts change
+// when kmers are merged or normalized)
+func (kdd *KDistD2) expected(cnt []float64) ([]float64, error) {
+	if kdd.Kmers == nil || len((*kdd.Kmers)[0]) != len(cnt) {
+		return nil, errors.New("kmer labels are required to compute expected counts")
+	}
+	km, err := NewKMarkov(kdd.K, kdd.Order)
+	if err != nil {
+		return nil, err
+	}
+	err = km.Estimate(kdd.Kmers, cnt)
+	if err != nil {
+		return nil, err
+	}
+	n := 0.0
+	for i := range cnt {
+		n += cnt[i]
+	}
+	return km.Expected(kdd.Kmers, n), nil
+}
+
+func (kdd *KDistD2) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+	x := mat.Col(nil, 0, a)
+	y := mat.Col(nil, 0, b)
+
+	// Center counts
+	var ex, ey []float64
+	if kdd.Variant != VariantD2 {
+		var err error
+		ex, err = kdd.expected(x)
+		if err != nil {
+			return err
+		}
+		ey, err = kdd.expected(y)
+		if err != nil {
+			return err
+		}
+		for i := range x {
+			x[i] -= ex[i]
+			y[i] -= ey[i]
+		}
+	}
+
+	// Statistic and the norms of both vectors
+	d2 := 0.0
+	nx := 0.0
+	ny := 0.0
+	for i := range x {
+		switch kdd.Variant {
+		case VariantD2:
+			d2 += x[i] * y[i]
+			nx += x[i] * x[i]
+			ny += y[i] * y[i]
+		case VariantD2S:
+			s := math.Sqrt(x[i]*x[i] + y[i]*y[i])
+			if s > 0 {
+				d2 += x[i] * y[i] / s
+				nx += x[i] * x[i] / s
+				ny += y[i] * y[i] / s
+			}
+		case VariantD2Star:
+			if ex[i] > 0 && ey[i] > 0 {
+				d2 += x[i] * y[i] / math.Sqrt(ex[i]*ey[i])
+				nx += x[i] * x[i] / ex[i]
+				ny += y[i] * y[i] / ey[i]
+			}
+		}
+	}
+
+	if nx == 0 && ny == 0 {
+		kdd.Dist = 0.0
+	} else if nx == 0 || ny == 0 {
+		kdd.Dist = 0.5
+	} else {
+		kdd.Dist = (1 - d2/(math.Sqrt(nx)*math.Sqrt(ny))) / 2
+	}
+	return nil
+}
+
+func (kdd *KDistD2) GetDistance() float64 {
+	return kdd.Dist
+}
+
+func (kdd *KDistD2) NeedSelfComparison() bool {
+	return false
+}
@@ -11,6 +11,15 @@ type KDistEuclidean struct {
 	Dist float64
 }
 
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Euclidean",
+		Aliases:     []string{"L2"},
+		Description: "Euclidean distance between count vectors",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistEuclidean(), nil },
+	})
+}
+
 func NewKDistEuclidean() *KDistEuclidean {
 	var kde KDistEuclidean
 	kde.Dist = float64(0.0)
@@ -43,6 +52,19 @@ func (kde *KDistEuclidean) Compute(a *mat.Dense, b *mat.Dense) error {
 	return (nil)
 }
 
+func (kde *KDistEuclidean) ComputeProfiles(a *KProfile, b *KProfile) error {
+	sum := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		sum += (x - y) * (x - y)
+	})
+	if err != nil {
+		return err
+	}
+	kde.Dist = math.Sqrt(sum)
+
+	return nil
+}
+
 func (kde *KDistEuclidean) GetDistance() float64 {
 	return kde.Dist
 }
@@ -0,0 +1,84 @@
+package kmer
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Jaccard distance on kmer presence: 1 - |A inter B| / |A union B| (0 if
+// both vectors are null)
+type KDistJaccard struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Jaccard",
+		Description: "Jaccard distance between kmer sets",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistJaccard(), nil },
+	})
+}
+
+func NewKDistJaccard() *KDistJaccard {
+	var kdj KDistJaccard
+	kdj.Dist = float64(0.0)
+	return &kdj
+}
+
+func (kdj *KDistJaccard) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	inter := 0.0
+	union := 0.0
+	for i := range aLen {
+		x := a.At(i, 0) != 0
+		y := b.At(i, 0) != 0
+		if x && y {
+			inter++
+		}
+		if x || y {
+			union++
+		}
+	}
+	kdj.Dist = 0.0
+	if union > 0 {
+		kdj.Dist = 1 - inter/union
+	}
+
+	return nil
+}
+
+func (kdj *KDistJaccard) ComputeProfiles(a *KProfile, b *KProfile) error {
+	inter := 0.0
+	union := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		if x != 0 && y != 0 {
+			inter++
+		}
+		if x != 0 || y != 0 {
+			union++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	kdj.Dist = 0.0
+	if union > 0 {
+		kdj.Dist = 1 - inter/union
+	}
+
+	return nil
+}
+
+func (kdj *KDistJaccard) GetDistance() float64 {
+	return kdj.Dist
+}
+
+func (kdj *KDistJaccard) NeedSelfComparison() bool {
+	return false
+}
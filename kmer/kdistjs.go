@@ -0,0 +1,134 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Error returned by probability-based distances on negative values
+var ErrNegativeCounts = errors.New("probability-based distances require non-negative kmer counts")
+
+// Distances ignoring the dense slots that are never counted (non-canonical
+// kmers in canonical mode, see GetKmersToSkip)
+type KDistSkipping interface {
+	KDist
+	SetKmersToSkip(*[]uint8)
+}
+
+// Jensen-Shannon divergence (base 2, in [0,1]) or distance (square root of
+// the divergence). Counts plus the pseudocount are turned into frequencies,
+// null frequencies do not contribute (0 log 0 = 0). Two null vectors are at
+// distance 0, a null vector is at distance 1 from any other vector.
+type KDistJS struct {
+	Dist        float64
+	PseudoCount float64
+	Divergence  bool
+	ToSkip      *[]uint8
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "JensenShannon",
+		Aliases:     []string{"JS"},
+		Description: "Jensen-Shannon distance between kmer frequencies",
+		Params:      []string{"PseudoCount"},
+		New:         func(p KDistParams) (KDist, error) { return NewKDistJS(p.PseudoCount, false), nil },
+	})
+	mustRegisterKDist(KDistMethod{
+		Name:        "JSDivergence",
+		Aliases:     []string{"JSD"},
+		Description: "Jensen-Shannon divergence between kmer frequencies",
+		Params:      []string{"PseudoCount"},
+		New:         func(p KDistParams) (KDist, error) { return NewKDistJS(p.PseudoCount, true), nil },
+	})
+}
+
+func NewKDistJS(pc float64, div bool) *KDistJS {
+	var kdj KDistJS
+	kdj.Dist = float64(0.0)
+	kdj.PseudoCount = pc
+	kdj.Divergence = div
+	return &kdj
+}
+
+func (kdj *KDistJS) SetKmersToSkip(skip *[]uint8) {
+	kdj.ToSkip = skip
+}
+
+// Turn counts plus a pseudocount into frequencies (nil if the sum is null),
+// skipped slots keep a null frequency
+func toFrequencies(a *mat.Dense, pc float64, skip *[]uint8) ([]float64, error) {
+	p := mat.Col(nil, 0, a)
+	sum := 0.0
+	for i := range p {
+		if p[i] < 0 {
+			return nil, ErrNegativeCounts
+		}
+		if skip != nil && (*skip)[i] != uint8(0) {
+			p[i] = 0
+			continue
+		}
+		p[i] += pc
+		sum += p[i]
+	}
+	if sum == 0 {
+		return nil, nil
+	}
+	for i := range p {
+		p[i] /= sum
+	}
+	return p, nil
+}
+
+func (kdj *KDistJS) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	p, err := toFrequencies(a, kdj.PseudoCount, kdj.ToSkip)
+	if err != nil {
+		return err
+	}
+	q, err := toFrequencies(b, kdj.PseudoCount, kdj.ToSkip)
+	if err != nil {
+		return err
+	}
+	if p == nil || q == nil {
+		kdj.Dist = 1.0
+		if p == nil && q == nil {
+			kdj.Dist = 0.0
+		}
+		return nil
+	}
+
+	div := 0.0
+	for i := range p {
+		m := (p[i] + q[i]) / 2
+		if p[i] > 0 {
+			div += p[i] * math.Log2(p[i]/m)
+		}
+		if q[i] > 0 {
+			div += q[i] * math.Log2(q[i]/m)
+		}
+	}
+	div = max(div/2, 0)
+
+	if kdj.Divergence {
+		kdj.Dist = div
+	} else {
+		kdj.Dist = math.Sqrt(div)
+	}
+	return nil
+}
+
+func (kdj *KDistJS) GetDistance() float64 {
+	return kdj.Dist
+}
+
+func (kdj *KDistJS) NeedSelfComparison() bool {
+	return false
+}
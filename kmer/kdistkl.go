@@ -0,0 +1,81 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Symmetrised Kullback-Leibler divergence KL(p|q) + KL(q|p) (natural log).
+// The pseudocount (that must be positive) is added to every count before
+// computing frequencies so that no frequency is null (but in skipped slots).
+type KDistSymKL struct {
+	Dist        float64
+	PseudoCount float64
+	ToSkip      *[]uint8
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "SymKL",
+		Aliases:     []string{"KL"},
+		Description: "Symmetrised Kullback-Leibler divergence between kmer frequencies",
+		Params:      []string{"PseudoCount"},
+		New: func(p KDistParams) (KDist, error) {
+			if p.PseudoCount <= 0 {
+				return nil, &KDistParamError{"PseudoCount", "the Kullback-Leibler pseudocount must be positive"}
+			}
+			return NewKDistSymKL(p.PseudoCount), nil
+		},
+	})
+}
+
+func NewKDistSymKL(pc float64) *KDistSymKL {
+	var kdk KDistSymKL
+	kdk.Dist = float64(0.0)
+	kdk.PseudoCount = pc
+	return &kdk
+}
+
+func (kdk *KDistSymKL) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+	if kdk.PseudoCount <= 0 {
+		return errors.New("the Kullback-Leibler pseudocount must be positive")
+	}
+
+	p, err := toFrequencies(a, kdk.PseudoCount, kdk.ToSkip)
+	if err != nil {
+		return err
+	}
+	q, err := toFrequencies(b, kdk.PseudoCount, kdk.ToSkip)
+	if err != nil {
+		return err
+	}
+
+	div := 0.0
+	for i := range p {
+		if p[i] > 0 {
+			div += (p[i] - q[i]) * math.Log(p[i]/q[i])
+		}
+	}
+	kdk.Dist = div
+
+	return nil
+}
+
+func (kdk *KDistSymKL) SetKmersToSkip(skip *[]uint8) {
+	kdk.ToSkip = skip
+}
+
+func (kdk *KDistSymKL) GetDistance() float64 {
+	return kdk.Dist
+}
+
+func (kdk *KDistSymKL) NeedSelfComparison() bool {
+	return false
+}
@@ -0,0 +1,65 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Manhattan (L1) distance: sum|a-b|
+type KDistManhattan struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Manhattan",
+		Aliases:     []string{"L1", "CityBlock"},
+		Description: "Sum of absolute count differences",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistManhattan(), nil },
+	})
+}
+
+func NewKDistManhattan() *KDistManhattan {
+	var kdm KDistManhattan
+	kdm.Dist = float64(0.0)
+	return &kdm
+}
+
+func (kdm *KDistManhattan) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	sum := 0.0
+	for i := range aLen {
+		sum += math.Abs(a.At(i, 0) - b.At(i, 0))
+	}
+	kdm.Dist = sum
+
+	return nil
+}
+
+func (kdm *KDistManhattan) ComputeProfiles(a *KProfile, b *KProfile) error {
+	sum := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		sum += math.Abs(x - y)
+	})
+	if err != nil {
+		return err
+	}
+	kdm.Dist = sum
+
+	return nil
+}
+
+func (kdm *KDistManhattan) GetDistance() float64 {
+	return kdm.Dist
+}
+
+func (kdm *KDistManhattan) NeedSelfComparison() bool {
+	return false
+}
@@ -7,12 +7,23 @@ import (
 	"gonum.org/v1/gonum/mat"
 )
 
+// Mash distance computed from the exact Jaccard index of two count vectors
+// (see KSketch for estimates from MinHash sketches)
 type KDistMash struct {
 	Dist    float64
 	K       int
 	Epsilon float64
 }
 
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Mash",
+		Description: "Mash distance from the exact Jaccard index of kmer sets",
+		Params:      []string{"K"},
+		New:         func(p KDistParams) (KDist, error) { return NewKDistMash(p.K), nil },
+	})
+}
+
 func NewKDistMash(k int) *KDistMash {
 	var kdm KDistMash
 	kdm.Dist = float64(0.0)
@@ -64,6 +75,29 @@ func (kdm *KDistMash) Compute(a *mat.Dense, b *mat.Dense) error {
 	return nil
 }
 
+func (kdm *KDistMash) ComputeProfiles(a *KProfile, b *KProfile) error {
+	inter := 0.0
+	union := 0.0
+	err := JoinProfiles(a, b, func(x, y float64) {
+		if x != 0 && y != 0 {
+			inter++
+		}
+		if x != 0 || y != 0 {
+			union++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	J := inter / union
+
+	// Mash distance
+	tmp := (2.0*J)/(1.0+J) + kdm.Epsilon
+	kdm.Dist = -1.0 / float64(kdm.K) * math.Log(tmp)
+
+	return nil
+}
+
 func (kdm *KDistMash) GetDistance() float64 {
 	return kdm.Dist
 }
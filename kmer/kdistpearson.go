@@ -0,0 +1,114 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
+)
+
+// Correlation distance 1 - r, based on the Pearson correlation of counts
+// or on the Spearman correlation (Pearson correlation of ranks, ties get
+// their average rank). If a vector is constant, the distance is 0 if both
+// vectors are equal and 1 otherwise.
+type KDistCorrelation struct {
+	Dist     float64
+	Spearman bool
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "Pearson",
+		Aliases:     []string{"Correlation"},
+		Description: "1 - Pearson correlation of counts",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistPearson(), nil },
+	})
+	mustRegisterKDist(KDistMethod{
+		Name:        "Spearman",
+		Description: "1 - Spearman rank correlation of counts",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistSpearman(), nil },
+	})
+}
+
+func NewKDistPearson() *KDistCorrelation {
+	var kdc KDistCorrelation
+	kdc.Dist = float64(0.0)
+	kdc.Spearman = false
+	return &kdc
+}
+
+func NewKDistSpearman() *KDistCorrelation {
+	var kdc KDistCorrelation
+	kdc.Dist = float64(0.0)
+	kdc.Spearman = true
+	return &kdc
+}
+
+// Ranks of values (starting at 1, ties get their average rank)
+func ranks(x []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortFunc(idx, func(a, b int) int {
+		if x[a] < x[b] {
+			return -1
+		} else if x[a] > x[b] {
+			return 1
+		}
+		return 0
+	})
+	r := make([]float64, len(x))
+	for i := 0; i < len(idx); {
+		j := i + 1
+		for j < len(idx) && x[idx[j]] == x[idx[i]] {
+			j++
+		}
+		avg := float64(i+j+1) / 2
+		for l := i; l < j; l++ {
+			r[idx[l]] = avg
+		}
+		i = j
+	}
+	return r
+}
+
+// Correlation distance between two vectors
+func correlationDistance(x []float64, y []float64) float64 {
+	r := stat.Correlation(x, y, nil)
+	if math.IsNaN(r) {
+		if slices.Equal(x, y) {
+			return 0.0
+		}
+		return 1.0
+	}
+	return 1 - r
+}
+
+func (kdc *KDistCorrelation) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	x := mat.Col(nil, 0, a)
+	y := mat.Col(nil, 0, b)
+	if kdc.Spearman {
+		x = ranks(x)
+		y = ranks(y)
+	}
+	kdc.Dist = correlationDistance(x, y)
+
+	return nil
+}
+
+func (kdc *KDistCorrelation) GetDistance() float64 {
+	return kdc.Dist
+}
+
+func (kdc *KDistCorrelation) NeedSelfComparison() bool {
+	return false
+}
@@ -0,0 +1,151 @@
+package kmer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+/*
+	Registry of kmer distance methods
+*/
+
+// Errors related to the registry
+var (
+	ErrUnsupportedDistance = errors.New("unsupported kmer distance method")
+	ErrDuplicateDistance   = errors.New("kmer distance method already registered")
+)
+
+// Invalid parameter of a distance method
+type KDistParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *KDistParamError) Error() string {
+	return fmt.Sprintf("invalid distance parameter %s: %s", e.Param, e.Reason)
+}
+
+// Parameters passed to distance constructors (each method uses the ones
+// listed in its Params)
+type KDistParams struct {
+	K           int
+	PseudoCount float64
+	MarkovOrder int
+	Canonical   bool // Counts of canonical kmers
+}
+
+func NewKDistParams(k int) KDistParams {
+	return KDistParams{K: k, PseudoCount: DefaultPseudoCount, MarkovOrder: DefaultMarkovOrder}
+}
+
+// A named distance method
+type KDistMethod struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Params      []string
+	New         func(p KDistParams) (KDist, error)
+}
+
+var (
+	kdistMutex   sync.RWMutex
+	kdistMethods = make(map[string]*KDistMethod)
+)
+
+// Register a distance method (names and aliases are case-insensitive and
+// must be unique)
+func RegisterKDist(m KDistMethod) error {
+	if m.Name == "" || m.New == nil {
+		return errors.New("a kmer distance method requires a name and a constructor")
+	}
+	kdistMutex.Lock()
+	defer kdistMutex.Unlock()
+
+	names := append([]string{m.Name}, m.Aliases...)
+	for _, n := range names {
+		if _, ok := kdistMethods[strings.ToLower(n)]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateDistance, n)
+		}
+	}
+	for _, n := range names {
+		kdistMethods[strings.ToLower(n)] = &m
+	}
+	return nil
+}
+
+// Register a built-in method (panics on error)
+func mustRegisterKDist(m KDistMethod) {
+	err := RegisterKDist(m)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Find a method from its name or one of its aliases
+func LookupKDist(name string) (*KDistMethod, error) {
+	kdistMutex.RLock()
+	defer kdistMutex.RUnlock()
+	m, ok := kdistMethods[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDistance, name)
+	}
+	return m, nil
+}
+
+// Create a distance from a method name
+func NewKDistByName(name string, p KDistParams) (KDist, error) {
+	m, err := LookupKDist(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.New(p)
+}
+
+// List registered methods sorted by name
+func KDistMethods() []*KDistMethod {
+	kdistMutex.RLock()
+	defer kdistMutex.RUnlock()
+	list := make([]*KDistMethod, 0)
+	for k, m := range kdistMethods {
+		if k == strings.ToLower(m.Name) {
+			list = append(list, m)
+		}
+	}
+	slices.SortFunc(list, func(a, b *KDistMethod) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return list
+}
+
+// Names of registered methods sorted by name
+func KDistNames() []string {
+	list := KDistMethods()
+	names := make([]string, len(list))
+	for i := range list {
+		names[i] = list[i].Name
+	}
+	return names
+}
+
+// Write the list of registered methods as a table
+func WriteKDistMethods(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Method\tAliases\tParameters\tDescription")
+	for _, m := range KDistMethods() {
+		aliases := strings.Join(m.Aliases, ",")
+		if aliases == "" {
+			aliases = "-"
+		}
+		params := strings.Join(m.Params, ",")
+		if params == "" {
+			params = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Name, aliases, params, m.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,121 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TETRA-style distance: 1 - Pearson correlation of the z-scores of
+// observed against expected kmer counts. Expected counts and variances
+// follow a maximal order (K-2) Markov model computed from the (K-1)- and
+// (K-2)-mer counts of the same sequence (marginals of its kmer counts).
+// Kmers with a null variance get a null z-score. Canonical counts are not
+// supported (marginals would mix both orientations).
+type KDistTetra struct {
+	Dist  float64
+	K     int
+	Kmers *[][]uint64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "TETRA",
+		Description: "1 - correlation of z-scores against a maximal order Markov model (3 <= K <= 32)",
+		Params:      []string{"K", "Canonical"},
+		New: func(p KDistParams) (KDist, error) {
+			if p.Canonical {
+				return nil, &KDistParamError{"Canonical", "TETRA z-scores require non-canonical kmer counts"}
+			}
+			kdt, err := NewKDistTetra(p.K)
+			if err != nil {
+				return nil, &KDistParamError{"K", err.Error()}
+			}
+			return kdt, nil
+		},
+	})
+}
+
+func NewKDistTetra(k int) (*KDistTetra, error) {
+	if k < 3 || k > MaxK64Bits {
+		return nil, errors.New("TETRA z-scores require a kmer length between 3 and 32")
+	}
+	var kdt KDistTetra
+	kdt.Dist = float64(0.0)
+	kdt.K = k
+	return &kdt, nil
+}
+
+func (kdt *KDistTetra) SetKmers(kmers *[][]uint64) {
+	kdt.Kmers = kmers
+}
+
+// Z-scores of a vector (computed at each call, as counts change when
+// kmers are merged or normalized)
+func (kdt *KDistTetra) zScores(a *mat.Dense) ([]float64, error) {
+	cnt := mat.Col(nil, 0, a)
+	if kdt.Kmers == nil || len((*kdt.Kmers)[0]) != len(cnt) {
+		return nil, errors.New("kmer labels are required to compute expected counts")
+	}
+	lab := (*kdt.Kmers)[0]
+	maskSuf := uint64(1)<<(2*(kdt.K-1)) - 1
+	maskMid := uint64(1)<<(2*(kdt.K-2)) - 1
+
+	// Prefix, suffix ((K-1)-mers) and middle ((K-2)-mer) counts
+	nPre := make(map[uint64]float64)
+	nSuf := make(map[uint64]float64)
+	nMid := make(map[uint64]float64)
+	for i := range cnt {
+		if cnt[i] < 0 {
+			return nil, ErrNegativeCounts
+		}
+		nPre[lab[i]>>2] += cnt[i]
+		nSuf[lab[i]&maskSuf] += cnt[i]
+		nMid[(lab[i]>>2)&maskMid] += cnt[i]
+	}
+
+	z := make([]float64, len(cnt))
+	for i := range cnt {
+		pre := nPre[lab[i]>>2]
+		suf := nSuf[lab[i]&maskSuf]
+		mid := nMid[(lab[i]>>2)&maskMid]
+		if mid == 0 {
+			continue
+		}
+		exp := pre * suf / mid
+		v := exp * (mid - pre) * (mid - suf) / (mid * mid)
+		if v > 0 {
+			z[i] = (cnt[i] - exp) / math.Sqrt(v)
+		}
+	}
+	return z, nil
+}
+
+func (kdt *KDistTetra) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	za, err := kdt.zScores(a)
+	if err != nil {
+		return err
+	}
+	zb, err := kdt.zScores(b)
+	if err != nil {
+		return err
+	}
+	kdt.Dist = correlationDistance(za, zb)
+
+	return nil
+}
+
+func (kdt *KDistTetra) GetDistance() float64 {
+	return kdt.Dist
+}
+
+func (kdt *KDistTetra) NeedSelfComparison() bool {
+	return false
+}
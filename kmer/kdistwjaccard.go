@@ -0,0 +1,87 @@
+package kmer
+
+import (
+	"errors"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Weighted Jaccard distance on counts: 1 - sum(min(a,b)) / sum(max(a,b))
+// (0 if both vectors are null, counts must be non-negative)
+type KDistWeightedJaccard struct {
+	Dist float64
+}
+
+func init() {
+	mustRegisterKDist(KDistMethod{
+		Name:        "WeightedJaccard",
+		Aliases:     []string{"Ruzicka"},
+		Description: "1 - sum of count minima / sum of count maxima",
+		New:         func(p KDistParams) (KDist, error) { return NewKDistWeightedJaccard(), nil },
+	})
+}
+
+func NewKDistWeightedJaccard() *KDistWeightedJaccard {
+	var kdw KDistWeightedJaccard
+	kdw.Dist = float64(0.0)
+	return &kdw
+}
+
+func (kdw *KDistWeightedJaccard) Compute(a *mat.Dense, b *mat.Dense) error {
+	aLen, _ := (*a).Dims()
+	bLen, _ := (*b).Dims()
+	if aLen != bLen {
+		return errors.New("cannot compare vectors of kmer counts with different lengths")
+	}
+
+	sMin := 0.0
+	sMax := 0.0
+	for i := range aLen {
+		x := a.At(i, 0)
+		y := b.At(i, 0)
+		if x < 0 || y < 0 {
+			return ErrNegativeCounts
+		}
+		sMin += min(x, y)
+		sMax += max(x, y)
+	}
+	kdw.Dist = 0.0
+	if sMax > 0 {
+		kdw.Dist = 1 - sMin/sMax
+	}
+
+	return nil
+}
+
+func (kdw *KDistWeightedJaccard) ComputeProfiles(a *KProfile, b *KProfile) error {
+	sMin := 0.0
+	sMax := 0.0
+	neg := false
+	err := JoinProfiles(a, b, func(x, y float64) {
+		if x < 0 || y < 0 {
+			neg = true
+		}
+		sMin += min(x, y)
+		sMax += max(x, y)
+	})
+	if err != nil {
+		return err
+	}
+	if neg {
+		return ErrNegativeCounts
+	}
+	kdw.Dist = 0.0
+	if sMax > 0 {
+		kdw.Dist = 1 - sMin/sMax
+	}
+
+	return nil
+}
+
+func (kdw *KDistWeightedJaccard) GetDistance() float64 {
+	return kdw.Dist
+}
+
+func (kdw *KDistWeightedJaccard) NeedSelfComparison() bool {
+	return false
+}
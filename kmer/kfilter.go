@@ -0,0 +1,99 @@
+package kmer
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Error returned when selecting kmers of counters that do not share kmer
+// labels
+var ErrNotMerged = errors.New("kmer labels must be merged first")
+
+// Selection of the kmers written by WriteKmerCounts. Thresholds apply to
+// raw counts (before normalization); a zero threshold is not checked.
+type KFilter struct {
+	MinCount   float64 // Minimal abundance
+	MaxCount   float64 // Maximal abundance
+	Total      bool    // Thresholds apply to the summed abundance of all samples instead of each sample
+	TopN       int     // Keep the N most abundant kmers (all if 0)
+	DropCore   bool    // Drop kmers present in every sample (several samples only)
+	DropUnique bool    // Drop kmers present in a single sample (several samples only)
+}
+
+// Check if the filter removes anything
+func (f KFilter) IsSet() bool {
+	return f.MinCount > 0 || f.MaxCount > 0 || f.TopN > 0 || f.DropCore || f.DropUnique
+}
+
+// Check if an abundance lies within the thresholds
+func (f KFilter) inRange(c float64) bool {
+	return (f.MinCount <= 0 || c >= f.MinCount) && (f.MaxCount <= 0 || c <= f.MaxCount)
+}
+
+// Select the kmers to write. Without the Total option, a kmer is kept if
+// its abundance lies within the thresholds in at least one sample. Kept
+// kmers are written in label order, or by decreasing total abundance in
+// top-N mode.
+func (km *Kmer) FilterKmers(f KFilter) error {
+	km.Selected = nil
+	if len(km.Counter) == 0 || !f.IsSet() {
+		return nil
+	}
+	if len(km.Counter) > 1 && km.K > MaxKSmall && !km.Merged {
+		return ErrNotMerged
+	}
+
+	nSeq := len(km.Counter)
+	nKmers := len((*km.Counter[0].GetKmers())[0])
+	cnt := make([][]float64, nSeq)
+	for i := range nSeq {
+		cnt[i] = mat.Col(nil, 0, km.Counter[i].GetCounts())
+	}
+	var toSkip []uint8
+	if km.Canonical {
+		toSkip = *km.Counter[0].GetKmersToSkip()
+	}
+
+	total := make([]float64, nKmers)
+	km.Selected = make([]int, 0)
+	for i := range nKmers {
+		if toSkip != nil && toSkip[i] != uint8(0) {
+			continue
+		}
+		nPres := 0
+		keep := false
+		for j := range nSeq {
+			total[i] += cnt[j][i]
+			if cnt[j][i] > 0 {
+				nPres++
+				keep = keep || f.inRange(cnt[j][i])
+			}
+		}
+		if nPres == 0 {
+			continue
+		}
+		if f.Total {
+			keep = f.inRange(total[i])
+		}
+		if nSeq > 1 && ((f.DropCore && nPres == nSeq) || (f.DropUnique && nPres == 1)) {
+			keep = false
+		}
+		if keep {
+			km.Selected = append(km.Selected, i)
+		}
+	}
+
+	if f.TopN > 0 {
+		slices.SortStableFunc(km.Selected, func(a, b int) int {
+			return cmp.Compare(total[b], total[a])
+		})
+		if len(km.Selected) > f.TopN {
+			km.Selected = km.Selected[:f.TopN]
+		}
+	}
+
+	return nil
+}
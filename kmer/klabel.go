@@ -49,6 +49,20 @@ func (kl *KLabel) Uint64ToBytes(ws *[][]uint64, out *[][]byte) error {
 }
 
 // Merge two list of uint64 => update object a
+// Convert the i-th kmer label into bytes (out must hold K bytes)
+func (kl *KLabel) Uint64ToBytesAt(ws *[][]uint64, i int, out *[]byte) error {
+	if kl.K <= MaxK64Bits {
+		kl.ParseUint64((*ws)[0][i], out, kl.K, kl.K-1)
+	} else if kl.K <= MaxK128Bits {
+		sub := kl.K - 32
+		kl.ParseUint64((*ws)[0][i], out, sub, sub-1)
+		kl.ParseUint64((*ws)[1][i], out, 32, kl.K-1)
+	} else {
+		return errors.New("kmer longer than 64 bases are not supported yet")
+	}
+	return nil
+}
+
 func (kl *KLabel) MergeUint64(a *[][]uint64, b *[][]uint64) error {
 	aLen := len((*a)[0])
 	bLen := len((*b)[0])
@@ -0,0 +1,110 @@
+package kmer
+
+import (
+	"errors"
+	"math"
+)
+
+// Default order of Markov backgrounds
+const DefaultMarkovOrder int = 1
+
+/*
+	Order-m Markov background of a sequence, estimated from the (m+1)-mer
+	and m-mer counts obtained as marginals of its kmer counts
+*/
+
+type KMarkov struct {
+	K     int
+	M     int
+	Mask  uint64
+	Start []float64 // Frequency of each (m+1)-mer
+	Trans []float64 // Transition probability P(last base | m first bases)
+}
+
+func NewKMarkov(K int, m int) (*KMarkov, error) {
+	if m < 0 || m > K-2 {
+		return nil, errors.New("the Markov order must be between 0 and K-2")
+	}
+	if m+1 > MaxKSmall {
+		return nil, errors.New("the Markov order is too high (maximal supported value is 7)")
+	}
+	var km KMarkov
+	n := int(math.Pow(4.0, float64(m+1)))
+	km.K = K
+	km.M = m
+	km.Mask = uint64(n - 1)
+	km.Start = make([]float64, n)
+	km.Trans = make([]float64, n)
+	return &km, nil
+}
+
+// Retrieve the base (0 to 3) at position j of the i-th kmer
+func kmerBase(kmers *[][]uint64, i int, j int, K int) uint64 {
+	if K <= MaxK64Bits {
+		return ((*kmers)[0][i] >> (2 * (K - 1 - j))) & 3
+	}
+	sub := K - 32
+	if j < sub {
+		return ((*kmers)[0][i] >> (2 * (sub - 1 - j))) & 3
+	}
+	return ((*kmers)[1][i] >> (2 * (K - 1 - j))) & 3
+}
+
+// Estimate the background from kmer counts: (m+1)-mers are counted at
+// each position of each kmer, m-mer counts are their marginals
+func (km *KMarkov) Estimate(kmers *[][]uint64, cnt []float64) error {
+	nm1 := make([]float64, len(km.Start))
+	for i := range cnt {
+		if cnt[i] < 0 {
+			return ErrNegativeCounts
+		}
+		if cnt[i] == 0 {
+			continue
+		}
+		w := uint64(0)
+		for j := range km.K {
+			w = ((w << 2) | kmerBase(kmers, i, j, km.K)) & km.Mask
+			if j >= km.M {
+				nm1[w] += cnt[i]
+			}
+		}
+	}
+
+	// m-mer counts (contexts) and (m+1)-mer frequencies
+	total := 0.0
+	nm := make([]float64, len(km.Start)/4)
+	for w := range nm1 {
+		nm[w>>2] += nm1[w]
+		total += nm1[w]
+	}
+	for w := range nm1 {
+		km.Start[w] = 0
+		km.Trans[w] = 0
+		if total > 0 {
+			km.Start[w] = nm1[w] / total
+		}
+		if nm[w>>2] > 0 {
+			km.Trans[w] = nm1[w] / nm[w>>2]
+		}
+	}
+	return nil
+}
+
+// Expected count of each kmer among n kmer occurrences
+func (km *KMarkov) Expected(kmers *[][]uint64, n float64) []float64 {
+	nKmers := len((*kmers)[0])
+	exp := make([]float64, nKmers)
+	for i := range nKmers {
+		w := uint64(0)
+		for j := 0; j <= km.M; j++ {
+			w = (w << 2) | kmerBase(kmers, i, j, km.K)
+		}
+		p := km.Start[w]
+		for j := km.M + 1; j < km.K && p > 0; j++ {
+			w = ((w << 2) | kmerBase(kmers, i, j, km.K)) & km.Mask
+			p *= km.Trans[w]
+		}
+		exp[i] = n * p
+	}
+	return exp
+}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/hdevillers/go-seq/seqio"
 	"gonum.org/v1/gonum/mat"
 	"gonum.org/v1/gonum/stat"
 )
 
+// Z-score of counts (constant vectors are set to zero)
 func Standardize(a *mat.Dense) {
 	// Extract count values into a float64
 	cnt := mat.Col(nil, 0, a)
@@ -21,30 +24,65 @@ func Standardize(a *mat.Dense) {
 	mean, sd := stat.MeanStdDev(cnt, nil)
 
 	for i := range len(cnt) {
-		cnt[i] = (cnt[i] - mean) / sd
+		if sd > 0 {
+			cnt[i] = (cnt[i] - mean) / sd
+		} else {
+			cnt[i] = 0
+		}
 	}
 
 	//a.Reset()
 	a.SetCol(0, cnt)
 }
 
+// Run a go-seq check function (that panics on error) and return
+// the error instead
+func CheckSeqIO(check func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	check()
+	return nil
+}
+
 type Kmer struct {
-	K         int
-	Canonical bool
-	Counter   []KCount
-	Labels    []string
-	Dist      KDist
-	IsStd     bool
-	RevComp   []byte
+	K            int
+	Canonical    bool
+	SoftMask     bool
+	Counter      []KCount
+	Labels       []string
+	Dist         KDist
+	DistName     string
+	GramMatrix   bool  // All-pairs distances from a Gram matrix (if supported by the method)
+	Merged       bool  // All counters share the same kmer labels
+	MaxMemory    int64 // Memory limit (bytes) of kmer buffers, unbounded if 0
+	TmpDir       string
+	Threads      int    // Number of counting workers
+	Selected     []int  // Kmers written by WriteKmerCounts (see FilterKmers), all if nil
+	CountsFormat string // Format of written counts: tsv (default), long or mtx
+	IsStd        bool
+	Norm         Normalizer
+	RevComp      []byte
+	Progress     *ProgressReporter
+	Sketches     []*KSketch
 }
 
 func NewKmer(k int, c bool) *Kmer {
 	var km Kmer
 	km.K = k
 	km.Canonical = c
+	km.SoftMask = false
 	km.Counter = make([]KCount, 0)
 	km.Labels = make([]string, 0)
 	km.IsStd = false
+	km.Threads = 1
+	km.Sketches = make([]*KSketch, 0)
 	km.RevComp = make([]byte, 256)
 	km.RevComp['A'] = 'T'
 	km.RevComp['C'] = 'G'
@@ -64,43 +102,330 @@ func (km *Kmer) ByteRevComp(w []byte) []byte {
 func (km *Kmer) LoadSequences(f, ff string) error {
 	// Open the fasta file
 	seqIn := seqio.NewReader(f, ff, false)
-	seqIn.CheckPanic()
+	err := CheckSeqIO(seqIn.CheckPanic)
+	if err != nil {
+		return err
+	}
 	defer seqIn.Close()
 
-	// Add a new counter
-	ic := len(km.Counter)
-	if km.K <= MaxKSmall {
-		km.Counter = append(km.Counter, NewKCountSmall(km.K, km.Canonical))
-	} else if km.K <= MaxK64Bits {
-		km.Counter = append(km.Counter, NewKCount32(km.K, km.Canonical))
-	} else if km.K <= MaxK128Bits {
-		km.Counter = append(km.Counter, NewKCount64(km.K, km.Canonical))
-	} else {
-		return errors.New("value of K is too high (maximal supported value is 64)")
+	// One counter per worker (memory-bounded for K > MaxKSmall if
+	// required, the limit being shared among workers)
+	nThreads := max(km.Threads, 1)
+	counters := make([]KCount, nThreads)
+	exts := make([]*KCountExternal, 0)
+	for i := range nThreads {
+		if km.MaxMemory > 0 && km.K > MaxKSmall {
+			ext, err := NewKCountExternal(km.K, km.Canonical, max(km.MaxMemory/int64(nThreads), MinExternalMemory), km.TmpDir)
+			if err != nil {
+				return err
+			}
+			defer ext.Clean()
+			exts = append(exts, ext)
+			counters[i] = ext
+		} else {
+			counters[i], err = NewKCount(km.K, km.Canonical)
+			if err != nil {
+				return err
+			}
+		}
+		counters[i].SetSoftMask(km.SoftMask)
+	}
+
+	// Counting workers fed by the sequence reader below
+	seqs := make(chan []byte, 2*nThreads)
+	errs := make([]error, nThreads)
+	kept := make([]bool, nThreads)
+	var wg sync.WaitGroup
+	for i := range nThreads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Sorted counters (K > MaxKSmall, in memory) count each
+			// sequence separately and sum partial counts by size
+			stacked := km.K > MaxKSmall && len(exts) == 0
+			var parts kcountStack
+			for s := range seqs {
+				if errs[i] != nil {
+					continue
+				}
+				kc := counters[i]
+				if stacked {
+					kc, _ = NewKCount(km.K, km.Canonical)
+					kc.SetSoftMask(km.SoftMask)
+				}
+				err := kc.Count(&s)
+				if err == nil {
+					kept[i] = true
+				} else if err != ErrNoSequenceKept {
+					errs[i] = err
+					continue
+				}
+				if stacked {
+					errs[i] = parts.push(kc)
+				}
+			}
+			if stacked && errs[i] == nil {
+				parts = append(kcountStack{counters[i]}, parts...)
+				counters[i], errs[i] = parts.sum()
+			}
+		}()
 	}
 
-	// Count kmers
+	// Read sequences
+	km.Progress.Start("scan "+f, 0)
+	nSeq := 0
 	for seqIn.Next() {
-		seqIn.CheckPanic()
-		seq := seqIn.Seq()
-		err := km.Counter[ic].Count(&seq.Sequence)
+		err = CheckSeqIO(seqIn.CheckPanic)
+		if err != nil {
+			break
+		}
+		seqs <- seqIn.Seq().Sequence
+		nSeq++
+		km.Progress.Update(nSeq)
+	}
+	close(seqs)
+	wg.Wait()
+	km.Progress.End(nSeq)
+	if err != nil {
+		return err
+	}
+	for i := range nThreads {
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	if !slices.Contains(kept, true) {
+		return ErrNoSequenceKept
+	}
+
+	// Merge sorted runs and keep the resulting counters
+	for i := range exts {
+		err = exts[i].Finish()
 		if err != nil {
 			return err
 		}
+		counters[i] = exts[i].KCount
 	}
 
+	// Sum partial counts into the first counter
+	for i := 1; i < nThreads; i++ {
+		err = counters[0].AddCounts(counters[i])
+		if err != nil {
+			return err
+		}
+		counters[i] = nil
+	}
+	km.Counter = append(km.Counter, counters[0])
+
 	// Add a label from the fasta file
+	km.Labels = append(km.Labels, sampleLabel(f))
+
+	return nil
+}
+
+// Sample label from a file name (without directory and extension)
+func sampleLabel(f string) string {
 	lab := filepath.Base(f)
 	lab, _ = strings.CutSuffix(lab, filepath.Ext(lab))
-	km.Labels = append(km.Labels, lab)
+	return lab
+}
+
+// Build a MinHash sketch of size s from a sequence file
+func (km *Kmer) SketchSequences(f, ff string, s int) error {
+	sk, err := NewKSketch(km.K, s, km.Canonical)
+	if err != nil {
+		return err
+	}
+	sk.SetSoftMask(km.SoftMask)
+	sk.Name = sampleLabel(f)
 
+	seqIn := seqio.NewReader(f, ff, false)
+	err = CheckSeqIO(seqIn.CheckPanic)
+	if err != nil {
+		return err
+	}
+	defer seqIn.Close()
+
+	km.Progress.Start("sketch "+f, 0)
+	nSeq := 0
+	for seqIn.Next() {
+		err = CheckSeqIO(seqIn.CheckPanic)
+		if err != nil {
+			return err
+		}
+		seq := seqIn.Seq()
+		err = sk.Sketch(&seq.Sequence)
+		if err != nil && err != ErrNoSequenceKept {
+			return err
+		}
+		nSeq++
+		km.Progress.Update(nSeq)
+	}
+	km.Progress.End(nSeq)
+
+	km.Sketches = append(km.Sketches, sk)
+	return nil
+}
+
+// Load sketches from a file written by WriteSketches
+func (km *Kmer) LoadSketches(f string) error {
+	sk, err := ReadSketchFile(f)
+	if err != nil {
+		return err
+	}
+	for i := range sk {
+		if sk[i].K != km.K || sk[i].Canonical != km.Canonical {
+			return ErrIncompatibleSketches
+		}
+	}
+	km.Sketches = append(km.Sketches, sk...)
 	return nil
 }
 
+// Set the distance method from a registered name or alias
+func (km *Kmer) SetDistMethod(name string, p KDistParams) error {
+	m, err := LookupKDist(name)
+	if err != nil {
+		return err
+	}
+	p.Canonical = km.Canonical
+	km.Dist, err = m.New(p)
+	if err != nil {
+		return err
+	}
+	km.DistName = m.Name
+	return nil
+}
+
+// Check if distances can be computed from sparse profiles, without
+// merging kmers (the normalization n must keep null counts null)
+func (km *Kmer) CanUseSparse(n Normalizer) bool {
+	_, ok := km.Dist.(KDistSparse)
+	return ok && PreservesZeros(n)
+}
+
+// Write pairwise distances between samples (counts must be merged unless
+// the method supports sparse profiles)
+func (km *Kmer) WriteDistances(ob string) error {
+	if km.Dist == nil {
+		return ErrUnsupportedDistance
+	}
+	f, err := os.Create(ob + "_Distance_" + km.DistName + ".tsv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	if km.Norm != nil {
+		fw.WriteString("# normalization: " + km.Norm.Name() + "\n")
+	}
+	kda, asym := km.Dist.(KDistAsymmetric)
+	if asym {
+		fw.WriteString("First.Sample\tSecond.Sample\tDistance\tReverse.Distance\n")
+	} else {
+		fw.WriteString("First.Sample\tSecond.Sample\tDistance\n")
+	}
+	kds, sparse := km.Dist.(KDistSparse)
+	if kdl, ok := km.Dist.(KDistLabeled); ok && len(km.Counter) > 0 {
+		kdl.SetKmers(km.Counter[0].GetKmers())
+	}
+	if kdk, ok := km.Dist.(KDistSkipping); ok && km.Canonical && len(km.Counter) > 0 {
+		kdk.SetKmersToSkip(km.Counter[0].GetKmersToSkip())
+	}
+	jMin := 1
+	if km.Dist.NeedSelfComparison() {
+		jMin = 0
+	}
+
+	// Compute all pairs at once if requested and counts share the same labels
+	var all *mat.SymDense
+	if kdp, ok := km.Dist.(KDistAllPairs); ok && km.GramMatrix && (km.Merged || km.K <= MaxKSmall) {
+		cnt := make([]*mat.Dense, len(km.Counter))
+		for i := range len(km.Counter) {
+			cnt[i] = km.Counter[i].GetCounts()
+		}
+		m, err := StackCounts(cnt)
+		if err != nil {
+			return err
+		}
+		all = kdp.ComputeAll(m)
+	}
+
+	for i := range len(km.Counter) {
+		for j := i + jMin; j < len(km.Counter); j++ {
+			if all != nil {
+				fmt.Fprintf(fw, "%s\t%s\t%f\n", km.Labels[i], km.Labels[j], all.At(i, j))
+				continue
+			}
+			if sparse {
+				err = kds.ComputeProfiles(NewKProfile(km.Counter[i]), NewKProfile(km.Counter[j]))
+			} else {
+				err = km.Dist.Compute(km.Counter[i].GetCounts(), km.Counter[j].GetCounts())
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(fw, "%s\t%s\t%f", km.Labels[i], km.Labels[j], km.Dist.GetDistance())
+			if asym {
+				fmt.Fprintf(fw, "\t%f", kda.GetReverseDistance())
+			}
+			fw.WriteByte('\n')
+		}
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Write sketches into a binary file
+func (km *Kmer) WriteSketches(ob string) error {
+	if len(km.Sketches) == 0 {
+		return errors.New("no sketch to write")
+	}
+	return WriteSketchFile(ob+"_Sketches.gsks", km.Sketches)
+}
+
+// Write pairwise Mash distances and p-values between sketches
+func (km *Kmer) WriteSketchDistances(ob string) error {
+	f, err := os.Create(ob + "_MashDistance.tsv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	fw.WriteString("First.Sample\tSecond.Sample\tDistance\tP.Value\tShared.Hashes\n")
+	ksd := NewKSketchDist()
+	for i := range len(km.Sketches) {
+		for j := i + 1; j < len(km.Sketches); j++ {
+			err = ksd.Compute(km.Sketches[i], km.Sketches[j])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(fw, "%s\t%s\t%.6f\t%.4g\t%d/%d\n", km.Sketches[i].Name, km.Sketches[j].Name,
+				ksd.GetDistance(), ksd.GetPValue(), ksd.Shared, ksd.Total)
+		}
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 func (km *Kmer) StandardizeCounts() {
+	km.NormalizeCounts(NewKNormZScore())
+}
+
+// Normalize counts (after merging kmers)
+func (km *Kmer) NormalizeCounts(n Normalizer) {
 	for i := range len(km.Counter) {
-		Standardize(km.Counter[i].GetCounts())
+		n.Normalize(km.Counter[i].GetCounts())
 	}
+	km.Norm = n
 	km.IsStd = true
 }
 
@@ -118,7 +443,7 @@ func (km *Kmer) MergeKmers() error {
 				kunion[0] = make([]uint64, 0)
 				kunion[1] = make([]uint64, 0)
 			} else {
-				return errors.New("value of K is too high (maximal supported value is 64)")
+				return ErrKTooLarge
 			}
 			kl := NewKLabel(km.K)
 			for i := range len(km.Counter) {
@@ -135,11 +460,83 @@ func (km *Kmer) MergeKmers() error {
 		}
 
 	}
+	km.Merged = true
 	return nil
 }
 
-// Write Kmer counts
+// Write the number of skipped bases of each input
+func (km *Kmer) WriteSkippedBases(ob string) error {
+	f, err := os.Create(ob + "_SkippedBases.tsv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	fw.WriteString("Sample\tDegenerated\tMasked\tTooShort\n")
+	for i := range len(km.Counter) {
+		fmt.Fprintf(fw, "%s\t%d\t%d\t%d\n", km.Labels[i], km.Counter[i].GetSkippedDegeneratedBases(),
+			km.Counter[i].GetSkippedMaskedBases(), km.Counter[i].GetSkippedTooShortBases())
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Sparse view of the (merged) kmer counts
+func (km *Kmer) countTable() *KCountTable {
+	var t KCountTable
+	kl := NewKLabel(km.K)
+	kNum := km.Counter[0].GetKmers()
+	t.NRows = len((*kNum)[0])
+	t.Rows = km.Selected
+	t.Samples = km.Labels
+	t.Counts = make([][]float64, len(km.Counter))
+	for i := range km.Counter {
+		t.Counts[i] = mat.Col(nil, 0, km.Counter[i].GetCounts())
+	}
+	if km.Norm != nil {
+		t.Norm = km.Norm.Name()
+	}
+
+	var toSkip []uint8
+	if km.Canonical {
+		toSkip = *km.Counter[0].GetKmersToSkip()
+	}
+	kByte := make([]byte, km.K)
+	lab := make([]byte, 0, 2*km.K+1)
+	t.Label = func(i int) []byte {
+		if toSkip != nil && toSkip[i] != uint8(0) {
+			return nil
+		}
+		if kl.Uint64ToBytesAt(kNum, i, &kByte) != nil {
+			return nil
+		}
+		lab = append(lab[:0], kByte...)
+		if km.Canonical {
+			lab = append(lab, '/')
+			lab = append(lab, km.ByteRevComp(kByte)...)
+		}
+		return lab
+	}
+	return &t
+}
+
+// Write Kmer counts (dense table or sparse format, see CountsFormat)
 func (km *Kmer) WriteKmerCounts(ob string) error {
+	if len(km.Counter) == 0 {
+		return errors.New("no kmer counts to write")
+	}
+	err := CheckCountsFormat(km.CountsFormat)
+	if err != nil {
+		return err
+	}
+	if km.CountsFormat == CountsFormatLong || km.CountsFormat == CountsFormatMTX {
+		return km.countTable().Write(ob+"_KmerCounts", km.CountsFormat)
+	}
+
 	f, err := os.Create(ob + "_KmerCounts.tsv")
 	if err != nil {
 		return err
@@ -149,7 +546,10 @@ func (km *Kmer) WriteKmerCounts(ob string) error {
 	// Create a buffer
 	fw := bufio.NewWriter(f)
 
-	// Create and write the header
+	// Create and write the header (with the normalization method)
+	if km.Norm != nil {
+		fw.WriteString("# normalization: " + km.Norm.Name() + "\n")
+	}
 	header := "Kmers"
 	nSeq := len(km.Counter)
 	for i := range nSeq {
@@ -157,15 +557,11 @@ func (km *Kmer) WriteKmerCounts(ob string) error {
 	}
 	fw.WriteString(header + "\n")
 
-	// Convert kmer uint64 labels into bytes
+	// Kmer uint64 labels are converted into bytes one at a time
 	kl := NewKLabel(km.K)
 	kNum := km.Counter[0].GetKmers()
 	nKmers := len((*kNum)[0])
-	kByte := make([][]byte, nKmers)
-	err = kl.Uint64ToBytes(kNum, &kByte)
-	if err != nil {
-		return err
-	}
+	kByte := make([]byte, km.K)
 
 	// Extract count values
 	cnt := make([][]float64, nSeq)
@@ -179,30 +575,46 @@ func (km *Kmer) WriteKmerCounts(ob string) error {
 	if km.IsStd {
 		numFmt = "\t%.04f"
 	}
-	if km.Canonical {
-		toSkip := km.Counter[0].GetKmersToSkip()
-		for i := range nKmers {
-			if (*toSkip)[i] == uint8(0) {
-				fw.Write(kByte[i])
-				wrc := km.ByteRevComp(kByte[i])
-				fw.WriteByte('/')
-				fw.Write(wrc)
-				for j := range nSeq {
-					fmt.Fprintf(fw, numFmt, cnt[j][i])
-				}
-				fw.WriteByte('\n')
+	writeRow := func(i int) error {
+		err := kl.Uint64ToBytesAt(kNum, i, &kByte)
+		if err != nil {
+			return err
+		}
+		fw.Write(kByte)
+		if km.Canonical {
+			fw.WriteByte('/')
+			fw.Write(km.ByteRevComp(kByte))
+		}
+		for j := range nSeq {
+			fmt.Fprintf(fw, numFmt, cnt[j][i])
+		}
+		return fw.WriteByte('\n')
+	}
+	if km.Selected != nil {
+		for _, i := range km.Selected {
+			err = writeRow(i)
+			if err != nil {
+				return err
 			}
 		}
 	} else {
+		var toSkip *[]uint8
+		if km.Canonical {
+			toSkip = km.Counter[0].GetKmersToSkip()
+		}
 		for i := range nKmers {
-			fw.Write(kByte[i])
-			for j := range nSeq {
-				fmt.Fprintf(fw, numFmt, cnt[j][i])
+			if toSkip != nil && (*toSkip)[i] != uint8(0) {
+				continue
+			}
+			err = writeRow(i)
+			if err != nil {
+				return err
 			}
-			fw.WriteByte('\n')
 		}
 	}
-	fw.Flush()
-
-	return nil
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
 }
@@ -0,0 +1,56 @@
+package kmer
+
+import (
+	"errors"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Default pseudocount added before taking logarithms
+const DefaultPseudoCount float64 = 0.5
+
+// Error returned for an unknown normalization method
+var ErrUnsupportedNorm = errors.New("unsupported normalization method (expected freq, clr, presence or zscore)")
+
+// Transformation of a vector of kmer counts (applied after merging kmer
+// labels so that missing kmers are taken into account)
+type Normalizer interface {
+	Normalize(*mat.Dense)
+	// Description recorded in output headers
+	Name() string
+}
+
+// Normalizers that keep null counts null: they give the same results
+// before and after merging kmer labels
+type KNormZeroPreserving interface {
+	PreservesZeros() bool
+}
+
+// Check if counts can be normalized without merging kmer labels (no
+// normalization is fine)
+func PreservesZeros(n Normalizer) bool {
+	if n == nil {
+		return true
+	}
+	kz, ok := n.(KNormZeroPreserving)
+	return ok && kz.PreservesZeros()
+}
+
+// Create a normalizer from its name (the pseudocount is only used by clr)
+func NewNormalizer(name string, pc float64) (Normalizer, error) {
+	switch strings.ToLower(name) {
+	case "freq":
+		return NewKNormFreq(), nil
+	case "clr":
+		if pc <= 0 {
+			return nil, errors.New("the clr pseudocount must be positive")
+		}
+		return NewKNormCLR(pc), nil
+	case "presence":
+		return NewKNormPresence(), nil
+	case "zscore":
+		return NewKNormZScore(), nil
+	}
+	return nil, ErrUnsupportedNorm
+}
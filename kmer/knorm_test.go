@@ -0,0 +1,53 @@
+package kmer
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Test normalizers on a regular and on an all-zero vector
+func TestNormalizers(t *testing.T) {
+	for _, name := range []string{"freq", "clr", "presence", "zscore"} {
+		n, err := NewNormalizer(name, DefaultPseudoCount)
+		if err != nil {
+			t.Fatalf("Failed to create the %s normalizer: %s", name, err.Error())
+		}
+
+		a := mat.NewDense(4, 1, []float64{0, 1, 3, 6})
+		n.Normalize(a)
+		cnt := mat.Col(nil, 0, a)
+		sum := 0.0
+		for i := range cnt {
+			sum += cnt[i]
+		}
+		switch name {
+		case "freq":
+			if math.Abs(sum-1) > 1e-12 {
+				t.Errorf("Expected frequencies summing to 1 but found %f.", sum)
+			}
+		case "clr", "zscore":
+			if math.Abs(sum) > 1e-12 {
+				t.Errorf("Expected %s values summing to 0 but found %f.", name, sum)
+			}
+		case "presence":
+			if sum != 3 {
+				t.Errorf("Expected 3 present kmers but found %f.", sum)
+			}
+		}
+
+		z := mat.NewDense(4, 1, nil)
+		n.Normalize(z)
+		for _, v := range mat.Col(nil, 0, z) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Errorf("The %s normalizer produced %f on an all-zero vector.", name, v)
+			}
+		}
+	}
+
+	_, err := NewNormalizer("unknown", DefaultPseudoCount)
+	if err != ErrUnsupportedNorm {
+		t.Errorf("Expected an error for an unknown normalization method.")
+	}
+}
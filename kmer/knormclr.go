@@ -0,0 +1,40 @@
+package kmer
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Centered log-ratio: log(x+pc) minus the mean of log(x+pc)
+type KNormCLR struct {
+	PseudoCount float64
+}
+
+func NewKNormCLR(pc float64) *KNormCLR {
+	var kn KNormCLR
+	kn.PseudoCount = pc
+	return &kn
+}
+
+func (kn *KNormCLR) Normalize(a *mat.Dense) {
+	cnt := mat.Col(nil, 0, a)
+	if len(cnt) == 0 {
+		return
+	}
+	mean := 0.0
+	for i := range cnt {
+		cnt[i] = math.Log(cnt[i] + kn.PseudoCount)
+		mean += cnt[i]
+	}
+	mean /= float64(len(cnt))
+	for i := range cnt {
+		cnt[i] -= mean
+	}
+	a.SetCol(0, cnt)
+}
+
+func (kn *KNormCLR) Name() string {
+	return fmt.Sprintf("clr(pseudocount=%g)", kn.PseudoCount)
+}
@@ -0,0 +1,34 @@
+package kmer
+
+import "gonum.org/v1/gonum/mat"
+
+// Relative frequencies (counts divided by their sum)
+type KNormFreq struct{}
+
+func NewKNormFreq() *KNormFreq {
+	var kn KNormFreq
+	return &kn
+}
+
+func (kn *KNormFreq) Normalize(a *mat.Dense) {
+	cnt := mat.Col(nil, 0, a)
+	sum := 0.0
+	for i := range cnt {
+		sum += cnt[i]
+	}
+	if sum == 0 {
+		return
+	}
+	for i := range cnt {
+		cnt[i] /= sum
+	}
+	a.SetCol(0, cnt)
+}
+
+func (kn *KNormFreq) Name() string {
+	return "freq"
+}
+
+func (kn *KNormFreq) PreservesZeros() bool {
+	return true
+}
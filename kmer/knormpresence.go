@@ -0,0 +1,31 @@
+package kmer
+
+import "gonum.org/v1/gonum/mat"
+
+// Presence (1) or absence (0) of each kmer
+type KNormPresence struct{}
+
+func NewKNormPresence() *KNormPresence {
+	var kn KNormPresence
+	return &kn
+}
+
+func (kn *KNormPresence) Normalize(a *mat.Dense) {
+	cnt := mat.Col(nil, 0, a)
+	for i := range cnt {
+		if cnt[i] > 0 {
+			cnt[i] = 1
+		} else {
+			cnt[i] = 0
+		}
+	}
+	a.SetCol(0, cnt)
+}
+
+func (kn *KNormPresence) Name() string {
+	return "presence"
+}
+
+func (kn *KNormPresence) PreservesZeros() bool {
+	return true
+}
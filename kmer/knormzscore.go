@@ -0,0 +1,19 @@
+package kmer
+
+import "gonum.org/v1/gonum/mat"
+
+// Z-score (see Standardize)
+type KNormZScore struct{}
+
+func NewKNormZScore() *KNormZScore {
+	var kn KNormZScore
+	return &kn
+}
+
+func (kn *KNormZScore) Normalize(a *mat.Dense) {
+	Standardize(a)
+}
+
+func (kn *KNormZScore) Name() string {
+	return "zscore"
+}
@@ -0,0 +1,88 @@
+package kmer
+
+import (
+	"errors"
+)
+
+/*
+	Sparse kmer profiles: distances between two profiles are computed by
+	merge-joining their sorted labels, without a shared label space
+*/
+
+// Sorted kmer labels (one or two words) and their counts
+type KProfile struct {
+	Kmers  [][]uint64
+	Counts []float64
+}
+
+// Distance computed directly from two sparse profiles (results are the
+// same as with Compute on merged count vectors)
+type KDistSparse interface {
+	KDist
+	ComputeProfiles(a *KProfile, b *KProfile) error
+}
+
+// Create a profile from the labels and counts of a counter (memory is
+// shared with the counter)
+func NewKProfile(kc KCount) *KProfile {
+	var kp KProfile
+	kp.Kmers = *kc.GetKmers()
+	n := len(kp.Kmers[0])
+	raw := kc.GetCounts().RawMatrix()
+	if raw.Stride == 1 {
+		kp.Counts = raw.Data[:n]
+	} else {
+		kp.Counts = make([]float64, n)
+		for i := range n {
+			kp.Counts[i] = raw.Data[i*raw.Stride]
+		}
+	}
+	return &kp
+}
+
+// Number of kmers in the profile
+func (kp *KProfile) Len() int {
+	return len(kp.Counts)
+}
+
+// Compare the i-th label of a profile with the j-th label of another one
+func compareLabels(a *KProfile, i int, b *KProfile, j int) int {
+	for w := range a.Kmers {
+		if a.Kmers[w][i] < b.Kmers[w][j] {
+			return -1
+		} else if a.Kmers[w][i] > b.Kmers[w][j] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Call f on the counts of each kmer of the union of two profiles (kmers
+// missing from a profile have a null count)
+func JoinProfiles(a *KProfile, b *KProfile, f func(x, y float64)) error {
+	if len(a.Kmers) != len(b.Kmers) {
+		return errors.New("cannot compare kmer profiles with different label sizes")
+	}
+	i, j := 0, 0
+	for i < a.Len() && j < b.Len() {
+		c := compareLabels(a, i, b, j)
+		if c == 0 {
+			f(a.Counts[i], b.Counts[j])
+			i++
+			j++
+		} else if c < 0 {
+			f(a.Counts[i], 0)
+			i++
+		} else {
+			f(0, b.Counts[j])
+			j++
+		}
+	}
+	for ; i < a.Len(); i++ {
+		f(a.Counts[i], 0)
+	}
+	for ; j < b.Len(); j++ {
+		f(0, b.Counts[j])
+	}
+	return nil
+}
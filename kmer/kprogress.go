@@ -0,0 +1,108 @@
+package kmer
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+/*
+	Progress reporting for long runs
+*/
+
+// Progress event: Total is zero when the amount of work is unknown
+type Progress struct {
+	Phase   string
+	Done    int
+	Total   int
+	Elapsed time.Duration
+	End     bool
+}
+
+type ProgressFunc func(Progress)
+
+// Dispatch progress events to a callback and/or a structured logger.
+// A nil reporter does nothing.
+type ProgressReporter struct {
+	Callback ProgressFunc
+	Logger   *slog.Logger
+	phase    string
+	total    int
+	start    time.Time
+}
+
+func NewProgressReporter(f ProgressFunc, l *slog.Logger) *ProgressReporter {
+	if f == nil && l == nil {
+		return nil
+	}
+	var pr ProgressReporter
+	pr.Callback = f
+	pr.Logger = l
+	return &pr
+}
+
+func (pr *ProgressReporter) emit(done int, end bool) {
+	ev := Progress{pr.phase, done, pr.total, time.Since(pr.start), end}
+	if pr.Callback != nil {
+		pr.Callback(ev)
+	}
+	if pr.Logger != nil {
+		if end {
+			pr.Logger.Info("phase completed", "phase", ev.Phase, "done", ev.Done, "total", ev.Total, "elapsed", ev.Elapsed)
+		} else {
+			pr.Logger.Debug("progress", "phase", ev.Phase, "done", ev.Done, "total", ev.Total)
+		}
+	}
+}
+
+// Start a new phase
+func (pr *ProgressReporter) Start(phase string, total int) {
+	if pr == nil {
+		return
+	}
+	pr.phase = phase
+	pr.total = total
+	pr.start = time.Now()
+	if pr.Logger != nil {
+		pr.Logger.Info("phase started", "phase", phase, "total", total)
+	}
+	pr.emit(0, false)
+}
+
+// Report the amount of work done in the current phase
+func (pr *ProgressReporter) Update(done int) {
+	if pr == nil {
+		return
+	}
+	pr.emit(done, false)
+}
+
+// Close the current phase
+func (pr *ProgressReporter) End(done int) {
+	if pr == nil {
+		return
+	}
+	pr.emit(done, true)
+}
+
+// Create a progress function printing a single refreshed line per phase
+// (at most every 200ms) and the phase timing when it ends
+func ProgressPrinter(w io.Writer) ProgressFunc {
+	var last time.Time
+	return func(ev Progress) {
+		if !ev.End && time.Since(last) < 200*time.Millisecond && ev.Done > 0 {
+			return
+		}
+		last = time.Now()
+		count := fmt.Sprintf("%d", ev.Done)
+		if ev.Total > 0 {
+			count = fmt.Sprintf("%d/%d (%.1f%%)", ev.Done, ev.Total, 100*float64(ev.Done)/float64(ev.Total))
+		}
+		if ev.End {
+			fmt.Fprintf(w, "\r\033[K%s: %s done in %s\n", ev.Phase, count, ev.Elapsed.Round(time.Millisecond))
+		} else {
+			fmt.Fprintf(w, "\r\033[K%s: %s", ev.Phase, count)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package kmer
+
+/*
+	Rolling kmer encoding on two words, independent of the counter type:
+	the first word holds the first K-32 bases (null if K <= 32) and the
+	second one the last 32 bases, as in counters labels
+*/
+
+var rollConvert = func() []uint64 {
+	conv := make([]uint64, 256)
+	conv['C'], conv['c'] = 1, 1
+	conv['G'], conv['g'] = 2, 2
+	conv['T'], conv['t'] = 3, 3
+	return conv
+}()
+
+// Call f on each kmer (canonical if c) of the fragments of a split sequence
+func rollKmers(frags [][]byte, k int, c bool, f func(hi, lo uint64)) {
+	// Masks of the two words
+	nHi := max(k-32, 0)
+	maskHi := uint64(1)<<(2*nHi) - 1
+	maskLo := ^uint64(0)
+	if k < 32 {
+		maskLo = uint64(1)<<(2*k) - 1
+	}
+	// Position of the first base of the reverse complement
+	rcShift := 2*k - 2
+
+	for iSeq := range len(frags) {
+		var hi, lo, rcHi, rcLo uint64
+		for i, b := range frags[iSeq] {
+			v := rollConvert[b]
+			hi = (hi<<2 | lo>>62) & maskHi
+			lo = (lo<<2 | v) & maskLo
+			if c {
+				rcLo = rcLo>>2 | (rcHi&3)<<62
+				rcHi >>= 2
+				if rcShift >= 64 {
+					rcHi |= (3 - v) << (rcShift - 64)
+				} else {
+					rcLo |= (3 - v) << rcShift
+				}
+			}
+			if i < k-1 {
+				continue
+			}
+			if c && (rcHi < hi || (rcHi == hi && rcLo < lo)) {
+				f(rcHi, rcLo)
+			} else {
+				f(hi, lo)
+			}
+		}
+	}
+}
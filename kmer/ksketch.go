@@ -0,0 +1,294 @@
+package kmer
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+/*
+	Bottom-s MinHash sketches built from the rolling kmer encoding
+*/
+
+const (
+	SketchMagic       string = "GSKS"
+	SketchVersion     uint64 = 1
+	DefaultSketchSize int    = 1000
+	DefaultSketchSeed uint64 = 42
+)
+
+// Errors related to sketches
+var (
+	ErrSketchSize           = errors.New("sketch size must be positive")
+	ErrSketchFormat         = errors.New("not a kmer sketch file")
+	ErrSketchVersion        = errors.New("unsupported kmer sketch file version")
+	ErrIncompatibleSketches = errors.New("sketches have different kmer lengths, seeds or strand settings")
+)
+
+// Max-heap of hash values
+type hashHeap []uint64
+
+func (h hashHeap) Len() int           { return len(h) }
+func (h hashHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x any)        { *h = append(*h, x.(uint64)) }
+func (h *hashHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// A sketch keeps the S smallest distinct hash values of the kmers of a
+// sample. Kmers are encoded on two words (the first K-32 bases, then the
+// last 32 bases) as in counters, so any K up to 64 is supported.
+type KSketch struct {
+	Name      string
+	K         int
+	S         int
+	Canonical bool
+	SoftMask  bool
+	Seed      uint64
+	NKmers    int      // Number of (non distinct) kmers hashed
+	Hashes    []uint64 // Sorted hash values, see GetHashes
+	heap      hashHeap
+	inHeap    map[uint64]bool
+	sorted    bool // Hashes are up to date with the heap
+}
+
+func NewKSketch(k int, s int, c bool) (*KSketch, error) {
+	if k <= 0 || k > MaxK128Bits {
+		return nil, ErrKTooLarge
+	}
+	if s <= 0 {
+		return nil, ErrSketchSize
+	}
+	var ks KSketch
+	ks.K = k
+	ks.S = s
+	ks.Canonical = c
+	ks.SoftMask = false
+	ks.Seed = DefaultSketchSeed
+	ks.Hashes = make([]uint64, 0)
+	ks.heap = make(hashHeap, 0, s)
+	ks.inHeap = make(map[uint64]bool)
+	ks.sorted = true
+	return &ks, nil
+}
+
+func (ks *KSketch) SetSoftMask(m bool) {
+	ks.SoftMask = m
+}
+
+// Mix a 64 bits word (MurmurHash3 finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Hash a kmer encoded on two words
+func (ks *KSketch) hash(hi, lo uint64) uint64 {
+	return mix64(lo ^ mix64(hi^ks.Seed))
+}
+
+// Insert a hash value if it belongs to the bottom S values
+func (ks *KSketch) insert(h uint64) {
+	if ks.inHeap[h] {
+		return
+	}
+	if len(ks.heap) < ks.S {
+		heap.Push(&ks.heap, h)
+		ks.inHeap[h] = true
+	} else if h < ks.heap[0] {
+		delete(ks.inHeap, ks.heap[0])
+		ks.heap[0] = h
+		heap.Fix(&ks.heap, 0)
+		ks.inHeap[h] = true
+	}
+}
+
+// Add the kmers of a sequence to the sketch
+func (ks *KSketch) Sketch(seq *[]byte) error {
+	seqSpl := NewKSplit(ks.K)
+	seqSpl.SetSoftMask(ks.SoftMask)
+	err := seqSpl.SplitSeq(seq)
+	if err != nil {
+		return err
+	}
+
+	rollKmers(seqSpl.SeqSplit, ks.K, ks.Canonical, func(hi, lo uint64) {
+		ks.insert(ks.hash(hi, lo))
+		ks.NKmers++
+	})
+	ks.sorted = false
+
+	return nil
+}
+
+// Sorted hash values of the sketch (sorted once after sketching, when
+// the sketch is written or compared)
+func (ks *KSketch) GetHashes() []uint64 {
+	if !ks.sorted {
+		ks.Hashes = slices.Clone([]uint64(ks.heap))
+		slices.Sort(ks.Hashes)
+		ks.sorted = true
+	}
+	return ks.Hashes
+}
+
+// Estimated number of distinct kmers: exact while the sketch is not full,
+// otherwise (S-1)/h with h the largest kept hash value scaled to [0,1]
+func (ks *KSketch) Cardinality() float64 {
+	h := ks.GetHashes()
+	n := float64(len(h))
+	if len(h) < ks.S || h[len(h)-1] == 0 {
+		return n
+	}
+	return max(n, (n-1)/(float64(h[len(h)-1])/math.MaxUint64))
+}
+
+// Check if two sketches can be compared
+func (ks *KSketch) Compatible(o *KSketch) bool {
+	return ks.K == o.K && ks.Seed == o.Seed && ks.Canonical == o.Canonical
+}
+
+// Write a sketch: settings followed by delta encoded hash values
+func (ks *KSketch) WriteBinary(w io.Writer) error {
+	err := WriteString(w, ks.Name)
+	if err != nil {
+		return err
+	}
+	canonical := uint64(0)
+	if ks.Canonical {
+		canonical = 1
+	}
+	hashes := ks.GetHashes()
+	for _, v := range []uint64{uint64(ks.K), uint64(ks.S), canonical, ks.Seed, uint64(ks.NKmers), uint64(len(hashes))} {
+		err = WriteUvarint(w, v)
+		if err != nil {
+			return err
+		}
+	}
+	prev := uint64(0)
+	for _, h := range hashes {
+		err = WriteUvarint(w, h-prev)
+		if err != nil {
+			return err
+		}
+		prev = h
+	}
+	return nil
+}
+
+// Read a sketch written by WriteBinary
+func ReadSketch(r *bufio.Reader) (*KSketch, error) {
+	name, err := ReadString(r)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]uint64, 6)
+	for i := range vals {
+		vals[i], err = ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ks, err := NewKSketch(int(vals[0]), int(vals[1]), vals[2] == 1)
+	if err != nil {
+		return nil, err
+	}
+	ks.Name = name
+	ks.Seed = vals[3]
+	ks.NKmers = int(vals[4])
+	ks.Hashes = make([]uint64, vals[5])
+	prev := uint64(0)
+	for i := range ks.Hashes {
+		d, err := ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		ks.Hashes[i] = prev + d
+		prev = ks.Hashes[i]
+		ks.inHeap[ks.Hashes[i]] = true
+	}
+
+	// Restore the heap so that more sequences can be added
+	ks.heap = slices.Clone(hashHeap(ks.Hashes))
+	heap.Init(&ks.heap)
+	return ks, nil
+}
+
+// Write a set of sketches into a file
+func WriteSketchFile(file string, sk []*KSketch) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	fw.WriteString(SketchMagic)
+	err = WriteUvarint(fw, SketchVersion)
+	if err != nil {
+		return err
+	}
+	err = WriteUvarint(fw, uint64(len(sk)))
+	if err != nil {
+		return err
+	}
+	for i := range sk {
+		err = sk[i].WriteBinary(fw)
+		if err != nil {
+			return err
+		}
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Read all sketches of a file
+func ReadSketchFile(file string) ([]*KSketch, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fr := bufio.NewReader(f)
+	magic := make([]byte, len(SketchMagic))
+	_, err = io.ReadFull(fr, magic)
+	if err != nil || string(magic) != SketchMagic {
+		return nil, ErrSketchFormat
+	}
+	v, err := ReadUvarint(fr)
+	if err != nil {
+		return nil, err
+	}
+	if v != SketchVersion {
+		return nil, ErrSketchVersion
+	}
+	n, err := ReadUvarint(fr)
+	if err != nil {
+		return nil, err
+	}
+	sk := make([]*KSketch, n)
+	for i := range sk {
+		sk[i], err = ReadSketch(fr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sk, nil
+}
@@ -0,0 +1,105 @@
+package kmer
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mathext"
+)
+
+// Mash distance estimated from two sketches. The Jaccard index is the
+// fraction of shared hash values among the S smallest values of the union
+// of both sketches (S being the smallest sketch size). The p-value is the
+// probability to observe at least as many shared values between random
+// sequences with the same numbers of distinct kmers.
+type KSketchDist struct {
+	Jaccard float64
+	Dist    float64
+	PValue  float64
+	Shared  int
+	Total   int
+}
+
+func NewKSketchDist() *KSketchDist {
+	var ksd KSketchDist
+	ksd.Dist = float64(0.0)
+	ksd.PValue = float64(1.0)
+	return &ksd
+}
+
+func (ksd *KSketchDist) Compute(a *KSketch, b *KSketch) error {
+	if !a.Compatible(b) {
+		return ErrIncompatibleSketches
+	}
+
+	// Merge the bottom values of the union
+	ha := a.GetHashes()
+	hb := b.GetHashes()
+	s := min(a.S, b.S)
+	ksd.Shared = 0
+	ksd.Total = 0
+	i, j := 0, 0
+	for ksd.Total < s && i < len(ha) && j < len(hb) {
+		if ha[i] == hb[j] {
+			ksd.Shared++
+			i++
+			j++
+		} else if ha[i] < hb[j] {
+			i++
+		} else {
+			j++
+		}
+		ksd.Total++
+	}
+	for ksd.Total < s && i < len(ha) {
+		i++
+		ksd.Total++
+	}
+	for ksd.Total < s && j < len(hb) {
+		j++
+		ksd.Total++
+	}
+
+	// Two empty sketches are identical
+	if ksd.Total == 0 {
+		ksd.Jaccard = 1.0
+		ksd.Dist = 0.0
+		ksd.PValue = 1.0
+		return nil
+	}
+
+	ksd.Jaccard = float64(ksd.Shared) / float64(ksd.Total)
+	if ksd.Shared == 0 {
+		ksd.Dist = 1.0
+	} else {
+		ksd.Dist = math.Max(0, -1.0/float64(a.K)*math.Log(2*ksd.Jaccard/(1+ksd.Jaccard)))
+	}
+	ksd.PValue = mashPValue(ksd.Shared, ksd.Total, a.K, a.Cardinality(), b.Cardinality())
+
+	return nil
+}
+
+// Probability that a random kmer of a sequence of n distinct kmers is found
+func kmerHitProbability(n float64, k int) float64 {
+	return 1.0 / (1.0 + math.Pow(4, float64(k))/n)
+}
+
+// Mash p-value of x shared values among t (na and nb distinct kmers)
+func mashPValue(x int, t int, k int, na float64, nb float64) float64 {
+	if x == 0 || na == 0 || nb == 0 {
+		return 1.0
+	}
+	pa := kmerHitProbability(na, k)
+	pb := kmerHitProbability(nb, k)
+	r := pa * pb / (pa + pb - pa*pb)
+	// P(X >= x) for X ~ Binomial(t, r), as a regularized incomplete beta
+	// function to keep precision on very small values
+	return mathext.RegIncBeta(float64(x), float64(t-x+1), r)
+}
+
+func (ksd *KSketchDist) GetDistance() float64 {
+	return ksd.Dist
+}
+
+func (ksd *KSketchDist) GetPValue() float64 {
+	return ksd.PValue
+}
@@ -0,0 +1,143 @@
+package kmer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Error returned when spectra or count databases are built from
+// normalized counts
+var ErrNormalizedCounts = errors.New("raw kmer counts are required (use them before normalization)")
+
+// Highest multiplicity of a spectrum by default (as jellyfish histo -h)
+const DefaultSpectrumMax int = 10000
+
+// Kmer abundance spectrum: number of distinct kmers occurring 1, 2, 3...
+// times, with a peak-based estimation of the genome size. Kmers seen less
+// often than the first valley of the histogram are considered as errors;
+// the highest bin after the valley gives the kmer coverage and the genome
+// size is the number of solid kmers divided by this coverage. Kmers seen
+// more than Max times are gathered in an overflow bin (Hist[Max+1]).
+type KSpectrum struct {
+	Hist       []int   // Hist[m]: number of distinct kmers seen m times
+	Max        int     // Highest multiplicity with its own bin (unbounded if 0)
+	Overflow   float64 // Number of kmers in the overflow bin
+	Distinct   int     // Number of distinct kmers
+	Valley     int     // First local minimum (1 if the histogram only decreases)
+	Peak       int     // Kmer coverage (0 if the histogram is empty)
+	Solid      float64 // Number of kmers seen at least Valley times
+	GenomeSize float64 // Solid kmers divided by the coverage
+}
+
+// Compute the spectrum of a counter (raw counts), multiplicities above max
+// share the overflow bin
+func NewKSpectrum(kc KCount, max int) *KSpectrum {
+	var sp KSpectrum
+	sp.Hist = make([]int, 1)
+	sp.Max = max
+	for _, c := range mat.Col(nil, 0, kc.GetCounts()) {
+		m := int(math.Round(c))
+		if m <= 0 {
+			continue
+		}
+		if max > 0 && m > max {
+			sp.Overflow += float64(m)
+			m = max + 1
+		}
+		for len(sp.Hist) <= m {
+			sp.Hist = append(sp.Hist, 0)
+		}
+		sp.Hist[m]++
+		sp.Distinct++
+	}
+	sp.estimate()
+	return &sp
+}
+
+// Locate the valley and the peak and estimate the genome size (overflow
+// kmers are solid but cannot be the peak)
+func (sp *KSpectrum) estimate() {
+	sp.Valley, sp.Peak, sp.Solid, sp.GenomeSize = 1, 0, 0, 0
+	if sp.Distinct == 0 {
+		return
+	}
+	n := len(sp.Hist)
+	if sp.Max > 0 && n > sp.Max+1 {
+		n = sp.Max + 1
+	}
+	for m := 1; m+1 < n; m++ {
+		if sp.Hist[m+1] > sp.Hist[m] {
+			sp.Valley = m
+			break
+		}
+	}
+	for m := sp.Valley; m < n; m++ {
+		if sp.Peak == 0 || sp.Hist[m] > sp.Hist[sp.Peak] {
+			sp.Peak = m
+		}
+		sp.Solid += float64(m) * float64(sp.Hist[m])
+	}
+	sp.Solid += sp.Overflow
+	if sp.Peak == 0 || sp.Hist[sp.Peak] == 0 {
+		// Only overflow kmers
+		sp.Peak = 0
+		sp.Solid = 0
+		return
+	}
+	sp.GenomeSize = sp.Solid / float64(sp.Peak)
+}
+
+// Write the kmer spectrum of each input (non-empty bins, the last one
+// gathers multiplicities above max) and the estimated genome sizes
+func (km *Kmer) WriteSpectra(ob string, max int) error {
+	if km.IsStd {
+		return ErrNormalizedCounts
+	}
+
+	f, err := os.Create(ob + "_KmerSpectrum.tsv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	g, err := os.Create(ob + "_GenomeSize.tsv")
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	fw := bufio.NewWriter(f)
+	gw := bufio.NewWriter(g)
+	fw.WriteString("Sample\tMultiplicity\tKmers\n")
+	gw.WriteString("Sample\tDistinctKmers\tValley\tCoverage\tSolidKmers\tGenomeSize\n")
+	for i := range len(km.Counter) {
+		sp := NewKSpectrum(km.Counter[i], max)
+		for m := 1; m < len(sp.Hist); m++ {
+			if sp.Hist[m] > 0 {
+				fmt.Fprintf(fw, "%s\t%d\t%d\n", km.Labels[i], m, sp.Hist[m])
+			}
+		}
+		if sp.Peak == 0 {
+			fmt.Fprintf(gw, "%s\t%d\tNA\tNA\tNA\tNA\n", km.Labels[i], sp.Distinct)
+		} else {
+			fmt.Fprintf(gw, "%s\t%d\t%d\t%d\t%.0f\t%.0f\n", km.Labels[i], sp.Distinct, sp.Valley, sp.Peak, sp.Solid, sp.GenomeSize)
+		}
+	}
+	err = fw.Flush()
+	if err != nil {
+		return err
+	}
+	err = gw.Flush()
+	if err != nil {
+		return err
+	}
+	err = g.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
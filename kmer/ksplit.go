@@ -2,11 +2,22 @@ package kmer
 
 import "errors"
 
+// Error returned when no fragment is long enough to contain a kmer
+var ErrNoSequenceKept = errors.New("no sequence kept (Kmer SplitSeq)")
+
+// Status of each base in KeptBases
+const (
+	BaseSkipped int = iota
+	BaseKept
+	BaseMasked
+)
+
 type KSplit struct {
 	K         int
 	KeptBases []int
 	SeqSplit  [][]byte
 	NSkipped  int
+	NMasked   int
 	NTooShort int
 }
 
@@ -15,33 +26,50 @@ func NewKSplit(K int) *KSplit {
 
 	ks.K = K
 	ks.NSkipped = 0
+	ks.NMasked = 0
 	ks.NTooShort = 0
 	ks.KeptBases = make([]int, 256)
-	ks.KeptBases['A'] = 1
-	ks.KeptBases['a'] = 1
-	ks.KeptBases['C'] = 1
-	ks.KeptBases['c'] = 1
-	ks.KeptBases['G'] = 1
-	ks.KeptBases['g'] = 1
-	ks.KeptBases['T'] = 1
-	ks.KeptBases['t'] = 1
+	ks.KeptBases['A'] = BaseKept
+	ks.KeptBases['a'] = BaseKept
+	ks.KeptBases['C'] = BaseKept
+	ks.KeptBases['c'] = BaseKept
+	ks.KeptBases['G'] = BaseKept
+	ks.KeptBases['g'] = BaseKept
+	ks.KeptBases['T'] = BaseKept
+	ks.KeptBases['t'] = BaseKept
 	ks.SeqSplit = [][]byte{}
 
 	return &ks
 }
 
+// Soft-masking mode: lowercase bases split the sequence like N does
+// (but are counted as masked bases)
+func (ks *KSplit) SetSoftMask(m bool) {
+	st := BaseKept
+	if m {
+		st = BaseMasked
+	}
+	for _, b := range []byte("acgt") {
+		ks.KeptBases[b] = st
+	}
+}
+
 func (ks *KSplit) SplitSeq(seq *[]byte) error {
 	iLoc := 0
 	sLen := len(*seq)
 
 	// Continue
 	for iLoc < sLen {
-		for (iLoc < sLen) && (ks.KeptBases[(*seq)[iLoc]] == 0) {
+		for (iLoc < sLen) && (ks.KeptBases[(*seq)[iLoc]] != BaseKept) {
+			if ks.KeptBases[(*seq)[iLoc]] == BaseMasked {
+				ks.NMasked++
+			} else {
+				ks.NSkipped++
+			}
 			iLoc++
-			ks.NSkipped++
 		}
 		from := iLoc
-		for (iLoc < sLen) && (ks.KeptBases[(*seq)[iLoc]] == 1) {
+		for (iLoc < sLen) && (ks.KeptBases[(*seq)[iLoc]] == BaseKept) {
 			iLoc++
 		}
 		iLen := iLoc - from
@@ -54,7 +82,7 @@ func (ks *KSplit) SplitSeq(seq *[]byte) error {
 	}
 
 	if len(ks.SeqSplit) == 0 {
-		return errors.New("no sequence kept (Kmer SplitSeq)")
+		return ErrNoSequenceKept
 	}
 	return nil
 }
@@ -227,3 +227,40 @@ func TestTooShortFragmentMiddle(t *testing.T) {
 		t.Errorf("Expected kept sequence length for the second split of 14 but found %d.", len(kd.SeqSplit[1]))
 	}
 }
+
+// Test soft-masked bases split the sequence
+func TestSoftMaskSplit(t *testing.T) {
+	kd := NewKSplit(5)
+	kd.SetSoftMask(true)
+	seq := []byte("ACGCTCGCGCGacgtTCGNTCGAGCTATGC") // 30 bases
+
+	err := kd.SplitSeq(&seq)
+
+	if err != nil {
+		t.Errorf("Unexpected error occurred while splitting a sequence: %s", err.Error())
+	}
+
+	if kd.NSkipped != 1 {
+		t.Errorf("Sequence splitting process skipped %d bases as unsupported nucleotides while 1 base should have been skipped.", kd.NSkipped)
+	}
+
+	if kd.NMasked != 4 {
+		t.Errorf("Sequence splitting process skipped %d bases as masked nucleotides while 4 bases should have been skipped.", kd.NMasked)
+	}
+
+	if kd.NTooShort != 3 {
+		t.Errorf("Sequence splitting process skipped %d bases in too short fragment(s) while 3 bases should have been skipped.", kd.NTooShort)
+	}
+
+	if len(kd.SeqSplit) != 2 {
+		t.Errorf("Expected one split but found %d split(s).", len(kd.SeqSplit)-1)
+	}
+
+	if len(kd.SeqSplit[0]) != 11 {
+		t.Errorf("Expected kept sequence length for the first split of 11 but found %d.", len(kd.SeqSplit[0]))
+	}
+
+	if len(kd.SeqSplit[1]) != 11 {
+		t.Errorf("Expected kept sequence length for the second split of 11 but found %d.", len(kd.SeqSplit[1]))
+	}
+}